@@ -0,0 +1,451 @@
+// Package cache simulates set associative caches driven by a stream of
+// memory accesses.
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Config describes a cache
+type Config struct {
+	Name          string
+	Size          uint64 // Capacity in bytes
+	Ways          int    // Associativity, zero for a fully associative cache
+	LineSize      uint64
+	Policy        string // Replacement policy, see Policies
+	WriteBack     bool   // Write hits are kept in the cache instead of written through
+	WriteAllocate bool   // Write misses fill the line instead of bypassing the cache
+}
+
+// DefaultConfig is used for the attributes missing from a description
+var DefaultConfig = Config{
+	Size:          1 << 20,
+	Ways:          8,
+	LineSize:      64,
+	Policy:        "lru",
+	WriteBack:     true,
+	WriteAllocate: true,
+}
+
+var sizeUnits = []struct {
+	suffix string
+	shift  uint
+}{
+	{"GiB", 30}, {"MiB", 20}, {"KiB", 10},
+	{"G", 30}, {"M", 20}, {"K", 10},
+	{"B", 0},
+}
+
+// ParseSize parses a size in bytes such as 64, 32KiB or 8M
+func ParseSize(s string) (uint64, error) {
+	num, shift := strings.TrimSpace(s), uint(0)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(num, unit.suffix) {
+			num, shift = strings.TrimSuffix(num, unit.suffix), unit.shift
+			break
+		}
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(num), 10, 64)
+	if err != nil || size<<shift>>shift != size {
+		return 0, fmt.Errorf("Invalid size %q", s)
+	}
+	return size << shift, nil
+}
+
+// FormatSize formats size with the largest binary unit it is a multiple of
+func FormatSize(size uint64) string {
+	for _, unit := range sizeUnits[:3] {
+		if size != 0 && size%(1<<unit.shift) == 0 {
+			return strconv.FormatUint(size>>unit.shift, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatUint(size, 10) + "B"
+}
+
+// ParseConfig parses a cache description of the form
+// 'name=llc,size=8MiB,ways=16,line=64,policy=lru,write=back,allocate=true',
+// attributes that are not given are taken from DefaultConfig
+func ParseConfig(desc string) (Config, error) {
+	cfg := DefaultConfig
+	for _, attr := range strings.Split(desc, ",") {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return cfg, fmt.Errorf("Invalid cache attribute %q in %q", attr, desc)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var err error
+		switch key {
+		case "name":
+			cfg.Name = value
+		case "size":
+			cfg.Size, err = ParseSize(value)
+		case "ways":
+			cfg.Ways, err = strconv.Atoi(value)
+		case "line":
+			cfg.LineSize, err = ParseSize(value)
+		case "policy":
+			cfg.Policy = value
+		case "write":
+			if value != "back" && value != "through" {
+				err = fmt.Errorf("Unknown write policy %q", value)
+			}
+			cfg.WriteBack = value == "back"
+		case "allocate":
+			cfg.WriteAllocate, err = strconv.ParseBool(value)
+		default:
+			return cfg, fmt.Errorf("Unknown cache attribute %q in %q", key, desc)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("Invalid cache attribute %q in %q: %w", key, desc, err)
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	if c.LineSize == 0 || c.Size == 0 || c.Ways < 0 {
+		return fmt.Errorf("Cache %s needs a positive size, line size and associativity", c)
+	}
+	ways := uint64(c.Ways)
+	if ways == 0 {
+		ways = c.Size / c.LineSize
+	}
+	if ways == 0 || c.Size%(c.LineSize*ways) != 0 {
+		return fmt.Errorf("Size of cache %s is not a multiple of the line size times the associativity", c)
+	}
+	if _, ok := policies[c.Policy]; !ok {
+		return fmt.Errorf("Unknown replacement policy %q, expected one of %s", c.Policy, strings.Join(Policies(), "/"))
+	}
+	// Policies may restrict the associativity, which only depends on the ways
+	_, err := newPolicy(c.Policy, 1, int(ways))
+	return err
+}
+
+func (c Config) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	ways := strconv.Itoa(c.Ways) + "way"
+	if c.Ways == 0 {
+		ways = "full"
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", FormatSize(c.Size), ways, FormatSize(c.LineSize), c.Policy)
+}
+
+// Stats counts the accesses to a cache and the resulting traffic to the next
+// level
+type Stats struct {
+	Reads, Writes           uint64
+	ReadMisses, WriteMisses uint64
+	Evictions               uint64 // Valid lines replaced
+	Writebacks              uint64 // Dirty lines replaced
+	WriteThroughs           uint64 // Writes forwarded without allocating or keeping them
+	Fills                   uint64 // Lines inserted by writebacks or victims of other caches
+	Invalidations           uint64 // Lines removed to keep the hierarchy inclusive
+}
+
+// Accesses returns the total amount of accesses
+func (s Stats) Accesses() uint64 {
+	return s.Reads + s.Writes
+}
+
+// Misses returns the total amount of misses
+func (s Stats) Misses() uint64 {
+	return s.ReadMisses + s.WriteMisses
+}
+
+// Hits returns the total amount of hits
+func (s Stats) Hits() uint64 {
+	return s.Accesses() - s.Misses()
+}
+
+// MissRatio returns the fraction of accesses that missed
+func (s Stats) MissRatio() float64 {
+	if s.Accesses() == 0 {
+		return 0
+	}
+	return float64(s.Misses()) / float64(s.Accesses())
+}
+
+// Sub returns the difference between s and an earlier snapshot
+func (s Stats) Sub(earlier Stats) Stats {
+	return Stats{
+		Reads:         s.Reads - earlier.Reads,
+		Writes:        s.Writes - earlier.Writes,
+		ReadMisses:    s.ReadMisses - earlier.ReadMisses,
+		WriteMisses:   s.WriteMisses - earlier.WriteMisses,
+		Evictions:     s.Evictions - earlier.Evictions,
+		Writebacks:    s.Writebacks - earlier.Writebacks,
+		WriteThroughs: s.WriteThroughs - earlier.WriteThroughs,
+		Fills:         s.Fills - earlier.Fills,
+		Invalidations: s.Invalidations - earlier.Invalidations,
+	}
+}
+
+type line struct {
+	addr  uint64 // Line number, the address divided by the line size
+	valid bool
+	dirty bool
+}
+
+// indexWays is the associativity above which the lines are looked up in a map
+// instead of scanning the ways of their set, e.g. for fully associative caches
+const indexWays = 64
+
+// Cache is a set associative cache, it only tracks which lines are present
+type Cache struct {
+	Config
+	Stats
+	sets   int
+	ways   int
+	lines  []line // Indexed by set*ways+way
+	policy Policy
+
+	// Position of every valid line and the invalid ways of every set, only
+	// kept for caches with more than indexWays ways
+	index map[uint64]int
+	free  [][]int
+}
+
+// New returns an empty cache
+func New(cfg Config) (*Cache, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	ways := cfg.Ways
+	if ways == 0 {
+		ways = int(cfg.Size / cfg.LineSize)
+	}
+	sets := int(cfg.Size / cfg.LineSize / uint64(ways))
+	policy, err := newPolicy(cfg.Policy, sets, ways)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		Config: cfg,
+		sets:   sets,
+		ways:   ways,
+		lines:  make([]line, sets*ways),
+		policy: policy,
+	}
+	if ways > indexWays {
+		c.index = map[uint64]int{}
+		c.free = make([][]int, sets)
+		for set := range c.free {
+			// Fill the lowest ways first, as the scan does
+			c.free[set] = make([]int, ways)
+			for way := range c.free[set] {
+				c.free[set][way] = ways - 1 - way
+			}
+		}
+	}
+	return c, nil
+}
+
+// Result describes the outcome of an access
+type Result struct {
+	Hit bool
+	// Evicted is set if a valid line was replaced to make room for the
+	// accessed line, Victim holds its address and Writeback whether it
+	// was dirty
+	Evicted   bool
+	Writeback bool
+	Victim    uint64
+	// WriteThrough is set if the write has to be forwarded to the next level
+	WriteThrough bool
+}
+
+// find returns the set of the line containing addr and its way, or -1 if the
+// line is not present
+func (c *Cache) find(addr uint64) (int, int) {
+	lineAddr := addr / c.LineSize
+	set := int(lineAddr % uint64(c.sets))
+	if c.index != nil {
+		if i, ok := c.index[lineAddr]; ok {
+			return set, i - set*c.ways
+		}
+		return set, -1
+	}
+	for way, l := range c.lines[set*c.ways : (set+1)*c.ways] {
+		if l.valid && l.addr == lineAddr {
+			return set, way
+		}
+	}
+	return set, -1
+}
+
+// Contains reports whether the line containing addr is present
+func (c *Cache) Contains(addr uint64) bool {
+	_, way := c.find(addr)
+	return way >= 0
+}
+
+func (c *Cache) count(write, hit bool) {
+	if write {
+		c.Writes++
+		if !hit {
+			c.WriteMisses++
+		}
+	} else {
+		c.Reads++
+		if !hit {
+			c.ReadMisses++
+		}
+	}
+}
+
+// Access looks up the line containing addr and fills it on a miss
+func (c *Cache) Access(addr uint64, write bool) Result {
+	set, way := c.find(addr)
+	c.count(write, way >= 0)
+	var res Result
+	if way >= 0 {
+		res.Hit = true
+		c.policy.Touch(set, way)
+		if write {
+			res.WriteThrough = c.write(&c.lines[set*c.ways+way])
+		}
+		return res
+	}
+	if write && !c.WriteAllocate {
+		c.WriteThroughs++
+		res.WriteThrough = true
+		return res
+	}
+	res, l := c.insert(addr, set)
+	if write {
+		res.WriteThrough = c.write(l)
+	}
+	return res
+}
+
+// AccessNext is Access for caches with an offline policy, next is the
+// position of the next access to the line or NeverUsed
+func (c *Cache) AccessNext(addr uint64, write bool, next uint64) Result {
+	if p, ok := c.policy.(OfflinePolicy); ok {
+		p.SetNextUse(next)
+	}
+	return c.Access(addr, write)
+}
+
+// Offline reports whether the replacement policy needs the future accesses,
+// see Lookahead
+func (c *Cache) Offline() bool {
+	_, ok := c.policy.(OfflinePolicy)
+	return ok
+}
+
+// Fill inserts the line containing addr without counting it as an access,
+// e.g. for a writeback from a higher level. A line that is already present
+// is marked dirty if dirty is set.
+func (c *Cache) Fill(addr uint64, dirty bool) Result {
+	set, way := c.find(addr)
+	if way < 0 {
+		c.Fills++
+		res, l := c.insert(addr, set)
+		l.dirty = dirty
+		return res
+	}
+	c.policy.Touch(set, way)
+	if dirty {
+		c.lines[set*c.ways+way].dirty = true
+	}
+	return Result{Hit: true}
+}
+
+// Invalidate removes the line containing addr, it reports whether the line
+// was present and dirty
+func (c *Cache) Invalidate(addr uint64) (bool, bool) {
+	set, way := c.find(addr)
+	if way < 0 {
+		return false, false
+	}
+	dirty := c.remove(set, way)
+	c.Invalidations++
+	return true, dirty
+}
+
+// Extract looks up the line containing addr and removes it on a hit, as done
+// by an exclusive cache handing the line to a higher level. It reports
+// whether the access hit and the line was dirty.
+func (c *Cache) Extract(addr uint64, write bool) (bool, bool) {
+	set, way := c.find(addr)
+	c.count(write, way >= 0)
+	if way < 0 {
+		return false, false
+	}
+	return true, c.remove(set, way)
+}
+
+// remove invalidates the line in way of set and reports whether it was dirty
+func (c *Cache) remove(set, way int) bool {
+	l := &c.lines[set*c.ways+way]
+	dirty := l.dirty
+	if c.index != nil {
+		delete(c.index, l.addr)
+		c.free[set] = append(c.free[set], way)
+	}
+	*l = line{}
+	return dirty
+}
+
+// insert fills the line containing addr into set, replacing a line if the set
+// is full, and returns the new line
+func (c *Cache) insert(addr uint64, set int) (Result, *line) {
+	var res Result
+	ways := c.lines[set*c.ways : (set+1)*c.ways]
+	way := -1
+	if c.index != nil {
+		if free := c.free[set]; len(free) > 0 {
+			way, c.free[set] = free[len(free)-1], free[:len(free)-1]
+		}
+	} else {
+		for w := range ways {
+			if !ways[w].valid {
+				way = w
+				break
+			}
+		}
+	}
+	if way < 0 {
+		way = c.policy.Victim(set)
+		victim := ways[way]
+		c.Evictions++
+		res.Evicted, res.Victim = true, victim.addr*c.LineSize
+		if victim.dirty {
+			c.Writebacks++
+			res.Writeback = true
+		}
+		if c.index != nil {
+			delete(c.index, victim.addr)
+		}
+	}
+	ways[way] = line{addr: addr / c.LineSize, valid: true}
+	if c.index != nil {
+		c.index[addr/c.LineSize] = set*c.ways + way
+	}
+	c.policy.Insert(set, way)
+	return res, &ways[way]
+}
+
+// write updates l for a write, it returns true if the write is forwarded
+func (c *Cache) write(l *line) bool {
+	if c.WriteBack {
+		l.dirty = true
+		return false
+	}
+	c.WriteThroughs++
+	return true
+}
+
+// AccessRange accesses every line overlapping the size bytes at addr
+func (c *Cache) AccessRange(addr, size uint64, write bool) {
+	if size == 0 {
+		size = 1
+	}
+	for l := addr / c.LineSize; l <= (addr+size-1)/c.LineSize; l++ {
+		c.Access(l*c.LineSize, write)
+	}
+}
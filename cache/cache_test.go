@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		size uint64
+		ok   bool
+	}{
+		{"64", 64, true},
+		{"64B", 64, true},
+		{"32KiB", 32 << 10, true},
+		{"8M", 8 << 20, true},
+		{" 2 GiB ", 2 << 30, true},
+		{"", 0, false},
+		{"KiB", 0, false},
+		{"-1", 0, false},
+		{"1.5K", 0, false},
+		{"4T", 0, false},
+		{"17179869184GiB", 0, false}, // Overflows 64 bits
+	}
+	for _, test := range tests {
+		size, err := ParseSize(test.s)
+		if (err == nil) != test.ok || size != test.size {
+			t.Errorf("ParseSize(%q) = %d, %v, expected %d", test.s, size, err, test.size)
+		}
+		if test.ok {
+			if back, err := ParseSize(FormatSize(size)); err != nil || back != size {
+				t.Errorf("%d formatted as %q parses as %d", size, FormatSize(size), back)
+			}
+		}
+	}
+	if s := FormatSize(100); s != "100B" {
+		t.Errorf("100 formatted as %q", s)
+	}
+	if s := FormatSize(3 << 20); s != "3MiB" {
+		t.Errorf("3MiB formatted as %q", s)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig("name=l1d,size=32KiB,ways=8,line=64,policy=plru,write=through,allocate=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{Name: "l1d", Size: 32 << 10, Ways: 8, LineSize: 64, Policy: "plru"}
+	if cfg != expected {
+		t.Errorf("Parsed %+v, expected %+v", cfg, expected)
+	}
+	cfg, err = ParseConfig("size=256KiB,ways=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = DefaultConfig
+	expected.Size, expected.Ways = 256<<10, 0
+	if cfg != expected || cfg.String() != "256KiB-full-64B-lru" {
+		t.Errorf("Parsed %+v (%s), expected %+v", cfg, cfg, expected)
+	}
+
+	for _, desc := range []string{
+		"size",
+		"colour=red",
+		"size=1000",
+		"size=32KiB,ways=-1",
+		"line=0",
+		"write=around",
+		"allocate=maybe",
+		"policy=mru",
+		"size=384,ways=6,policy=plru",
+		// Policies scanning every way do not support large sets
+		"size=1MiB,ways=0,policy=opt",
+		"size=1MiB,ways=0,policy=drrip",
+	} {
+		if _, err := ParseConfig(desc); err == nil {
+			t.Errorf("Accepted %q", desc)
+		}
+	}
+}
+
+func TestCacheWrites(t *testing.T) {
+	newCache := func(writeBack, writeAllocate bool) *Cache {
+		c, err := New(Config{Size: 2 * testLineSize, Ways: 2, LineSize: testLineSize, Policy: "lru", WriteBack: writeBack, WriteAllocate: writeAllocate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// A dirty line is written back when it is replaced
+	c := newCache(true, true)
+	if res := c.Access(0, true); res.Hit || res.WriteThrough {
+		t.Errorf("Write miss: %+v", res)
+	}
+	c.Access(testLineSize, false)
+	res := c.Access(2*testLineSize, false)
+	if !res.Evicted || !res.Writeback || res.Victim != 0 {
+		t.Errorf("Replacing the dirty line: %+v", res)
+	}
+	res = c.Access(3*testLineSize, false)
+	if !res.Evicted || res.Writeback || res.Victim != testLineSize {
+		t.Errorf("Replacing the clean line: %+v", res)
+	}
+	expected := Stats{Reads: 3, Writes: 1, ReadMisses: 3, WriteMisses: 1, Evictions: 2, Writebacks: 1}
+	if c.Stats != expected {
+		t.Errorf("Write back: %+v, expected %+v", c.Stats, expected)
+	}
+
+	// Written lines are forwarded and stay clean
+	c = newCache(false, true)
+	if res := c.Access(0, true); res.Hit || !res.WriteThrough || !c.Contains(0) {
+		t.Errorf("Write through miss: %+v", res)
+	}
+	if res := c.Access(0, true); !res.Hit || !res.WriteThrough {
+		t.Errorf("Write through hit: %+v", res)
+	}
+	c.Access(testLineSize, false)
+	if res := c.Access(2*testLineSize, false); !res.Evicted || res.Writeback {
+		t.Errorf("Replacing a written line of a write through cache: %+v", res)
+	}
+	if c.WriteThroughs != 2 || c.Writebacks != 0 {
+		t.Errorf("Write through: %+v", c.Stats)
+	}
+
+	// Write misses bypass the cache, write hits do not
+	c = newCache(true, false)
+	if res := c.Access(0, true); res.Hit || !res.WriteThrough || c.Contains(0) {
+		t.Errorf("No allocate write miss: %+v", res)
+	}
+	c.Access(testLineSize, false)
+	if res := c.Access(testLineSize, true); !res.Hit || res.WriteThrough {
+		t.Errorf("No allocate write hit: %+v", res)
+	}
+	expected = Stats{Reads: 1, Writes: 2, ReadMisses: 1, WriteMisses: 1, WriteThroughs: 1}
+	if c.Stats != expected {
+		t.Errorf("No allocate: %+v, expected %+v", c.Stats, expected)
+	}
+
+	// Fills are not accesses but keep their dirty state
+	c = newCache(true, true)
+	c.Fill(0, true)
+	if present, dirty := c.Invalidate(0); !present || !dirty {
+		t.Errorf("Filled line present: %v, dirty: %v", present, dirty)
+	}
+	if c.Accesses() != 0 || c.Fills != 1 || c.Invalidations != 1 {
+		t.Errorf("Fill: %+v", c.Stats)
+	}
+}
+
+// lruModel is a fully associative LRU cache holding its lines from least to
+// most recently used
+type lruModel struct {
+	lines []uint64
+	ways  int
+}
+
+func (m *lruModel) remove(line uint64) bool {
+	for i, l := range m.lines {
+		if l == line {
+			m.lines = append(m.lines[:i], m.lines[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *lruModel) access(line uint64) (bool, uint64, bool) {
+	hit := m.remove(line)
+	var victim uint64
+	evicted := !hit && len(m.lines) == m.ways
+	if evicted {
+		victim, m.lines = m.lines[0], m.lines[1:]
+	}
+	m.lines = append(m.lines, line)
+	return hit, victim, evicted
+}
+
+// TestFullyAssociative compares a fully associative cache, which looks its
+// lines up in a map, with a model on random accesses and invalidations
+func TestFullyAssociative(t *testing.T) {
+	const ways = 4 * indexWays
+	c, err := New(Config{Size: ways * testLineSize, LineSize: testLineSize, Policy: "lru", WriteBack: true, WriteAllocate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.index == nil {
+		t.Fatal("Fully associative cache without index")
+	}
+	model := &lruModel{ways: ways}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		line := uint64(r.Intn(ways * 3 / 2))
+		if r.Intn(10) == 0 {
+			present, _ := c.Invalidate(line * testLineSize)
+			if present != model.remove(line) {
+				t.Fatalf("Access %d: invalidated line %d present: %v", i, line, present)
+			}
+			continue
+		}
+		res := c.Access(line*testLineSize, false)
+		hit, victim, evicted := model.access(line)
+		if res.Hit != hit || res.Evicted != evicted || (evicted && res.Victim != victim*testLineSize) {
+			t.Fatalf("Access %d to line %d: %+v, expected hit: %v, evicted: %v, victim: %d", i, line, res, hit, evicted, victim)
+		}
+	}
+	if len(c.index) != len(model.lines) {
+		t.Errorf("%d lines indexed, expected %d", len(c.index), len(model.lines))
+	}
+}
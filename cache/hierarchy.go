@@ -0,0 +1,401 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// Inclusion describes how the contents of a level relate to the levels
+// closer to the CPU
+type Inclusion uint8
+
+const (
+	// NonInclusive levels are filled on every miss but do not invalidate
+	// the levels above when replacing a line
+	NonInclusive Inclusion = iota
+	// Inclusive levels hold every line of the levels above, replacing a
+	// line invalidates it above
+	Inclusive
+	// Exclusive levels only hold the lines evicted from the levels above, a
+	// hit moves the line up
+	Exclusive
+)
+
+var inclusionNames = [...]string{
+	NonInclusive: "noninclusive",
+	Inclusive:    "inclusive",
+	Exclusive:    "exclusive",
+}
+
+func (i Inclusion) String() string {
+	if int(i) < len(inclusionNames) {
+		return inclusionNames[i]
+	}
+	return "unknown"
+}
+
+// ParseInclusion returns the inclusion policy with the given name
+func ParseInclusion(name string) (Inclusion, error) {
+	for i, n := range inclusionNames {
+		if n == name {
+			return Inclusion(i), nil
+		}
+	}
+	return NonInclusive, fmt.Errorf("Unknown inclusion policy %q", name)
+}
+
+// Contents describes which accesses a cache of a level serves
+type Contents uint8
+
+const (
+	// Unified caches serve instruction fetches and data accesses
+	Unified Contents = iota
+	// Instructions caches only serve instruction fetches
+	Instructions
+	// Data caches only serve data accesses
+	Data
+)
+
+var contentsNames = [...]string{
+	Unified:      "unified",
+	Instructions: "inst",
+	Data:         "data",
+}
+
+func (c Contents) String() string {
+	if int(c) < len(contentsNames) {
+		return contentsNames[c]
+	}
+	return "unknown"
+}
+
+// LevelConfig describes a cache of a hierarchy
+type LevelConfig struct {
+	Config
+	Level     int // One is closest to the CPU
+	Contents  Contents
+	Shared    bool // One cache for all CPUs instead of one per CPU
+	Inclusion Inclusion
+}
+
+// ParseLevelConfig parses a cache description as accepted by ParseConfig
+// with the additional attributes level=<1..>, type=<unified|inst|data>,
+// shared=<true|false> and inclusion=<noninclusive|inclusive|exclusive>
+func ParseLevelConfig(desc string) (LevelConfig, error) {
+	cfg := LevelConfig{Level: 1}
+	var rest []string
+	for _, attr := range strings.Split(desc, ",") {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			rest = append(rest, attr)
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var err error
+		switch key {
+		case "level":
+			cfg.Level, err = strconv.Atoi(value)
+			if err == nil && cfg.Level < 1 {
+				err = fmt.Errorf("Level has to be positive")
+			}
+		case "type":
+			err = fmt.Errorf("Unknown cache type %q", value)
+			for c, name := range contentsNames {
+				if name == value {
+					cfg.Contents, err = Contents(c), nil
+				}
+			}
+		case "shared":
+			cfg.Shared, err = strconv.ParseBool(value)
+		case "inclusion":
+			cfg.Inclusion, err = ParseInclusion(value)
+		default:
+			rest = append(rest, attr)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("Invalid cache attribute %q in %q: %w", key, desc, err)
+		}
+	}
+	var err error
+	cfg.Config, err = ParseConfig(strings.Join(rest, ","))
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Name == "" {
+		cfg.Name = "l" + strconv.Itoa(cfg.Level)
+		if cfg.Contents != Unified {
+			cfg.Name += cfg.Contents.String()[:1]
+		}
+	}
+	return cfg, nil
+}
+
+// level holds the caches of one level, either one unified cache or a split
+// instruction and data cache for every CPU or for all CPUs if shared
+type level struct {
+	inst, data LevelConfig
+	split      bool
+	caches     [][2]*Cache // Instruction and data cache of every CPU
+}
+
+func (l *level) cache(cpu int, fetch bool) *Cache {
+	if l.data.Shared {
+		cpu = 0
+	}
+	for len(l.caches) <= cpu {
+		var caches [2]*Cache
+		// The configurations are validated when the hierarchy is created
+		caches[1], _ = New(l.data.Config)
+		caches[0] = caches[1]
+		if l.split {
+			caches[0], _ = New(l.inst.Config)
+		}
+		l.caches = append(l.caches, caches)
+	}
+	if fetch {
+		return l.caches[cpu][0]
+	}
+	return l.caches[cpu][1]
+}
+
+// Hierarchy simulates multiple levels of caches, private levels have a cache
+// for every CPU. Traffic leaving the last level is reported to OnMemory.
+type Hierarchy struct {
+	levels []*level
+	// OnMemory is called for every request to memory with the command a
+	// gem5 cache would issue and the address of the line
+	OnMemory     func(cmd trace.Command, addr uint64)
+	MemoryReads  uint64
+	MemoryWrites uint64
+	lineSize     uint64
+}
+
+// NewHierarchy creates a hierarchy of the given caches, every level has
+// either one unified cache or an instruction and a data cache. All caches
+// need the same line size.
+func NewHierarchy(cfgs []LevelConfig) (*Hierarchy, error) {
+	byLevel := map[int][]LevelConfig{}
+	for _, cfg := range cfgs {
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		if IsOffline(cfg.Policy) {
+			return nil, fmt.Errorf("Replacement policy %s needs the future accesses and is only supported for single caches", cfg.Policy)
+		}
+		byLevel[cfg.Level] = append(byLevel[cfg.Level], cfg)
+	}
+	numbers := make([]int, 0, len(byLevel))
+	for n := range byLevel {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	h := &Hierarchy{}
+	for _, n := range numbers {
+		l := &level{}
+		caches := byLevel[n]
+		switch {
+		case len(caches) == 1 && caches[0].Contents == Unified:
+			l.inst, l.data = caches[0], caches[0]
+		case len(caches) == 2 && caches[0].Contents != Unified && caches[1].Contents != Unified && caches[0].Contents != caches[1].Contents:
+			l.inst, l.data, l.split = caches[0], caches[1], true
+			if l.inst.Contents == Data {
+				l.inst, l.data = l.data, l.inst
+			}
+			if l.inst.Shared != l.data.Shared || l.inst.Inclusion != l.data.Inclusion {
+				return nil, fmt.Errorf("Instruction and data cache of level %d have to be shared and inclusive alike", n)
+			}
+		default:
+			return nil, fmt.Errorf("Level %d needs either a unified cache or an instruction and a data cache", n)
+		}
+		for _, cfg := range caches {
+			if h.lineSize == 0 {
+				h.lineSize = cfg.LineSize
+			}
+			if cfg.LineSize != h.lineSize {
+				return nil, fmt.Errorf("All caches of a hierarchy need the same line size")
+			}
+		}
+		if len(h.levels) > 0 && h.levels[len(h.levels)-1].data.Shared && !l.data.Shared {
+			return nil, fmt.Errorf("Level %d is private below a shared level", n)
+		}
+		h.levels = append(h.levels, l)
+	}
+	if len(h.levels) == 0 {
+		return nil, fmt.Errorf("Hierarchy without caches")
+	}
+	return h, nil
+}
+
+// LineSize returns the line size shared by all caches
+func (h *Hierarchy) LineSize() uint64 {
+	return h.lineSize
+}
+
+// NamedCache is a cache of a hierarchy with a name identifying its level and
+// CPU
+type NamedCache struct {
+	Name string
+	*Cache
+}
+
+// Caches returns all caches created so far, ordered by level and CPU
+func (h *Hierarchy) Caches() []NamedCache {
+	var caches []NamedCache
+	for _, l := range h.levels {
+		for cpu, c := range l.caches {
+			prefix := ""
+			if !l.data.Shared {
+				prefix = "cpu" + strconv.Itoa(cpu) + "."
+			}
+			if l.split {
+				caches = append(caches, NamedCache{prefix + l.inst.Name, c[0]})
+			}
+			caches = append(caches, NamedCache{prefix + l.data.Name, c[1]})
+		}
+	}
+	return caches
+}
+
+// request is an access travelling down the hierarchy
+type request struct {
+	cpu   int
+	fetch bool
+	write bool // The CPU access is a write, lower levels see a read of the line
+}
+
+// Access simulates an access of the CPU a.CPU, instruction fetches are served
+// by the instruction caches
+func (h *Hierarchy) Access(a trace.Access) {
+	req := request{cpu: a.CPU, fetch: a.Kind == trace.Fetch, write: a.Kind.IsWrite()}
+	size := uint64(a.Size)
+	if size == 0 {
+		size = 1
+	}
+	for l := a.Addr / h.lineSize; l <= (a.Addr+size-1)/h.lineSize; l++ {
+		h.access(0, req, l*h.lineSize, req.write)
+	}
+}
+
+// access looks up addr at level i, write is set if the access itself writes
+// the line. It returns whether the line handed up is dirty, which only
+// happens for lines moved up from an exclusive level.
+func (h *Hierarchy) access(i int, req request, addr uint64, write bool) bool {
+	if i == len(h.levels) {
+		h.memory(req, addr)
+		return false
+	}
+	l := h.levels[i]
+	c := l.cache(req.cpu, req.fetch)
+	if i > 0 && l.data.Inclusion == Exclusive {
+		hit, dirty := c.Extract(addr, write)
+		if !hit {
+			dirty = h.access(i+1, req, addr, false)
+		}
+		return dirty
+	}
+	res := c.Access(addr, write)
+	if res.Evicted {
+		h.evict(i, req, res.Victim, res.Writeback)
+	}
+	if !res.Hit && (!write || c.WriteAllocate) {
+		if h.access(i+1, req, addr, false) {
+			if c.WriteBack {
+				c.Fill(addr, true)
+			} else {
+				h.writeback(i+1, req, addr, trace.WritebackDirty)
+			}
+		}
+	}
+	if res.WriteThrough {
+		h.writeback(i+1, req, addr, trace.WriteReq)
+	}
+	return false
+}
+
+// evict handles the replacement of victim at level i
+func (h *Hierarchy) evict(i int, req request, victim uint64, dirty bool) {
+	l := h.levels[i]
+	if i > 0 && l.data.Inclusion == Inclusive {
+		dirty = h.invalidateAbove(i, req.cpu, victim) || dirty
+	}
+	if i+1 < len(h.levels) && h.levels[i+1].data.Inclusion == Exclusive {
+		// Exclusive levels are filled with the victims of the level above
+		c := h.levels[i+1].cache(req.cpu, req.fetch)
+		if dirty && !c.WriteBack {
+			h.writeback(i+2, req, victim, trace.WritebackDirty)
+			dirty = false
+		}
+		if res := c.Fill(victim, dirty); res.Evicted {
+			h.evict(i+1, req, res.Victim, res.Writeback)
+		}
+		return
+	}
+	if dirty {
+		h.writeback(i+1, req, victim, trace.WritebackDirty)
+	}
+}
+
+// writeback writes the dirty line at addr to level i, cmd is the command
+// issued if the write reaches memory
+func (h *Hierarchy) writeback(i int, req request, addr uint64, cmd trace.Command) {
+	if i >= len(h.levels) {
+		h.MemoryWrites++
+		if h.OnMemory != nil {
+			h.OnMemory(cmd, addr)
+		}
+		return
+	}
+	c := h.levels[i].cache(req.cpu, req.fetch)
+	if !c.WriteBack {
+		if c.Contains(addr) {
+			c.Fill(addr, false)
+		}
+		h.writeback(i+1, req, addr, cmd)
+		return
+	}
+	if res := c.Fill(addr, true); res.Evicted {
+		h.evict(i, req, res.Victim, res.Writeback)
+	}
+}
+
+// invalidateAbove removes addr from the levels above the inclusive level i
+// that it covers, it reports whether any of the copies was dirty
+func (h *Hierarchy) invalidateAbove(i, cpu int, addr uint64) bool {
+	dirty := false
+	for _, l := range h.levels[:i] {
+		for c, caches := range l.caches {
+			if !h.levels[i].data.Shared && c != cpu {
+				continue
+			}
+			for j, cache := range caches {
+				if j == 1 && caches[0] == caches[1] {
+					break
+				}
+				if _, d := cache.Invalidate(addr); d {
+					dirty = true
+				}
+			}
+		}
+	}
+	return dirty
+}
+
+// memory issues the read of a line that missed in every level
+func (h *Hierarchy) memory(req request, addr uint64) {
+	h.MemoryReads++
+	if h.OnMemory == nil {
+		return
+	}
+	switch {
+	case req.fetch:
+		h.OnMemory(trace.ReadCleanReq, addr)
+	case req.write:
+		h.OnMemory(trace.ReadExReq, addr)
+	default:
+		h.OnMemory(trace.ReadSharedReq, addr)
+	}
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// memoryRequest is a request the hierarchy sent to memory
+type memoryRequest struct {
+	cmd  trace.Command
+	line uint64
+}
+
+// newTestHierarchy returns a hierarchy of one CPU with a level per
+// description and the requests it sends to memory
+func newTestHierarchy(t *testing.T, descs ...string) (*Hierarchy, map[string]*Cache, *[]memoryRequest) {
+	var cfgs []LevelConfig
+	for _, desc := range descs {
+		cfg, err := ParseLevelConfig(desc + ",line=64,policy=lru")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	h, err := NewHierarchy(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := &[]memoryRequest{}
+	h.OnMemory = func(cmd trace.Command, addr uint64) {
+		*requests = append(*requests, memoryRequest{cmd, addr / testLineSize})
+	}
+	caches := map[string]*Cache{}
+	for _, l := range h.levels {
+		l.cache(0, false)
+	}
+	for _, c := range h.Caches() {
+		caches[c.Name] = c.Cache
+	}
+	return h, caches, requests
+}
+
+// access accesses the lines in order, writing the lines listed in writes
+func access(h *Hierarchy, lines []uint64, writes ...uint64) {
+	for _, line := range lines {
+		kind := trace.Read
+		for _, w := range writes {
+			if w == line {
+				kind = trace.Write
+			}
+		}
+		h.Access(trace.Access{Addr: line * testLineSize, Size: 8, Kind: kind})
+	}
+}
+
+// checkContents checks which of the lines 0 to 7 every cache holds
+func checkContents(t *testing.T, caches map[string]*Cache, contents map[string][]uint64) {
+	t.Helper()
+	for name, lines := range contents {
+		for line := uint64(0); line < 8; line++ {
+			expected := false
+			for _, l := range lines {
+				expected = expected || l == line
+			}
+			if present := caches[name].Contains(line * testLineSize); present != expected {
+				t.Errorf("Line %d in %s: %v, expected %v", line, name, present, expected)
+			}
+		}
+	}
+}
+
+// checkRequests compares the requests sent to memory in order
+func checkRequests(t *testing.T, requests, expected []memoryRequest) {
+	t.Helper()
+	if len(requests) != len(expected) {
+		t.Fatalf("Memory requests %v, expected %v", requests, expected)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("Memory request %d is %v, expected %v", i, requests[i], expected[i])
+		}
+	}
+}
+
+// TestInclusiveBackInvalidation replaces a line of the inclusive L2 that is
+// dirty in the L1, the invalidated copy has to be written back
+func TestInclusiveBackInvalidation(t *testing.T) {
+	h, caches, requests := newTestHierarchy(t,
+		"level=1,size=128,ways=2",
+		"level=2,size=128,ways=2,inclusion=inclusive")
+	// Line 0 stays in the L1 but is least recently used in the L2, which
+	// does not see the hit
+	access(h, []uint64{0, 1, 0, 2}, 0)
+	checkContents(t, caches, map[string][]uint64{
+		"cpu0.l1": {2},
+		"cpu0.l2": {1, 2},
+	})
+	if caches["cpu0.l1"].Invalidations != 1 {
+		t.Errorf("%d invalidations in the L1, expected 1", caches["cpu0.l1"].Invalidations)
+	}
+	checkRequests(t, *requests, []memoryRequest{
+		{trace.ReadExReq, 0},
+		{trace.ReadSharedReq, 1},
+		{trace.WritebackDirty, 0},
+		{trace.ReadSharedReq, 2},
+	})
+}
+
+// TestExclusiveHit moves a line that was evicted to the exclusive L2 back up
+func TestExclusiveHit(t *testing.T) {
+	h, caches, requests := newTestHierarchy(t,
+		"level=1,size=128,ways=2",
+		"level=2,size=256,ways=4,inclusion=exclusive")
+	access(h, []uint64{0, 1, 2})
+	checkContents(t, caches, map[string][]uint64{
+		"cpu0.l1": {1, 2},
+		"cpu0.l2": {0},
+	})
+	access(h, []uint64{0})
+	checkContents(t, caches, map[string][]uint64{
+		"cpu0.l1": {0, 2},
+		"cpu0.l2": {1},
+	})
+	l2 := caches["cpu0.l2"].Stats
+	if l2.Reads != 4 || l2.ReadMisses != 3 || l2.Fills != 2 {
+		t.Errorf("L2 %+v, expected 4 reads, 3 misses and 2 fills", l2)
+	}
+	checkRequests(t, *requests, []memoryRequest{
+		{trace.ReadSharedReq, 0},
+		{trace.ReadSharedReq, 1},
+		{trace.ReadSharedReq, 2},
+	})
+}
+
+// TestExclusiveVictims fills the exclusive L2 with the victims of the L1, a
+// dirty victim stays dirty until the L2 replaces it or hands it back up
+func TestExclusiveVictims(t *testing.T) {
+	h, caches, requests := newTestHierarchy(t,
+		"level=1,size=128,ways=2",
+		"level=2,size=128,ways=2,inclusion=exclusive")
+	access(h, []uint64{0, 1, 2}, 0)
+	checkContents(t, caches, map[string][]uint64{
+		"cpu0.l1": {1, 2},
+		"cpu0.l2": {0},
+	})
+	if h.MemoryWrites != 0 {
+		t.Errorf("%d memory writes, expected the dirty victim to stay in the L2", h.MemoryWrites)
+	}
+
+	// The dirty line moves up, back down when the L1 replaces it again and
+	// is written back when the L2 replaces it
+	access(h, []uint64{0, 3, 4})
+	checkContents(t, caches, map[string][]uint64{
+		"cpu0.l1": {3, 4},
+		"cpu0.l2": {2, 0},
+	})
+	access(h, []uint64{5, 6})
+	checkContents(t, caches, map[string][]uint64{
+		"cpu0.l1": {5, 6},
+		"cpu0.l2": {3, 4},
+	})
+	if h.MemoryWrites != 1 {
+		t.Errorf("%d memory writes, expected 1", h.MemoryWrites)
+	}
+	checkRequests(t, *requests, []memoryRequest{
+		{trace.ReadExReq, 0},
+		{trace.ReadSharedReq, 1},
+		{trace.ReadSharedReq, 2},
+		{trace.ReadSharedReq, 3},
+		{trace.ReadSharedReq, 4},
+		{trace.ReadSharedReq, 5},
+		{trace.WritebackDirty, 0},
+		{trace.ReadSharedReq, 6},
+	})
+}
@@ -0,0 +1,89 @@
+package cache
+
+// Lookahead delays the accesses to a cache so the next use of every line is
+// known to offline policies such as opt. Accesses to a line that is not used
+// again within depth accesses are treated as never used again, the result is
+// exact if depth is at least the amount of accesses.
+type Lookahead struct {
+	*Cache
+	queue     []pendingAccess   // Ring buffer of the delayed accesses
+	last      map[uint64]uint64 // Position of the last queued access to every line
+	queued    uint64
+	simulated uint64
+}
+
+type pendingAccess struct {
+	addr  uint64
+	write bool
+	next  uint64
+}
+
+// NewLookahead returns a look-ahead buffer of depth accesses in front of c
+func NewLookahead(c *Cache, depth int) *Lookahead {
+	if depth < 1 {
+		depth = 1
+	}
+	return &Lookahead{
+		Cache: c,
+		queue: make([]pendingAccess, depth),
+		last:  map[uint64]uint64{},
+	}
+}
+
+// Access queues an access to the line containing addr, the oldest queued
+// access is simulated once the buffer is full
+func (l *Lookahead) Access(addr uint64, write bool) {
+	if l.queued-l.simulated == uint64(len(l.queue)) {
+		l.simulate()
+	}
+	lineAddr := addr / l.LineSize
+	if prev, ok := l.last[lineAddr]; ok {
+		l.queue[prev%uint64(len(l.queue))].next = l.queued
+	}
+	l.last[lineAddr] = l.queued
+	l.queue[l.queued%uint64(len(l.queue))] = pendingAccess{addr: addr, write: write, next: NeverUsed}
+	l.queued++
+}
+
+// AccessRange queues an access to every line overlapping the size bytes at
+// addr
+func (l *Lookahead) AccessRange(addr, size uint64, write bool) {
+	if size == 0 {
+		size = 1
+	}
+	for line := addr / l.LineSize; line <= (addr+size-1)/l.LineSize; line++ {
+		l.Access(line*l.LineSize, write)
+	}
+}
+
+func (l *Lookahead) simulate() {
+	a := l.queue[l.simulated%uint64(len(l.queue))]
+	lineAddr := a.addr / l.LineSize
+	if l.last[lineAddr] == l.simulated {
+		delete(l.last, lineAddr)
+	}
+	l.AccessNext(a.addr, a.write, a.next)
+	l.simulated++
+}
+
+// Flush simulates all queued accesses
+func (l *Lookahead) Flush() {
+	l.Drain(l.queued)
+}
+
+// Drain simulates queued accesses until n accesses reached the cache
+func (l *Lookahead) Drain(n uint64) {
+	for l.simulated < n && l.simulated < l.queued {
+		l.simulate()
+	}
+}
+
+// Queued returns the amount of line accesses queued so far
+func (l *Lookahead) Queued() uint64 {
+	return l.queued
+}
+
+// Simulated returns the amount of line accesses that reached the cache
+func (l *Lookahead) Simulated() uint64 {
+	return l.simulated
+}
@@ -0,0 +1,353 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Policy decides which line of a set is replaced on a miss. A policy is
+// created for every cache and keeps the replacement state of all its sets.
+type Policy interface {
+	// Touch is called when the line in way of set is hit
+	Touch(set, way int)
+	// Insert is called when a new line is filled into way of set
+	Insert(set, way int)
+	// Victim returns the way of a full set that is replaced
+	Victim(set int) int
+}
+
+// OfflinePolicy is a policy that needs to know when the accessed line is used
+// again, SetNextUse is called before every access with the position of the
+// next access to the line or NeverUsed
+type OfflinePolicy interface {
+	Policy
+	SetNextUse(next uint64)
+}
+
+// NeverUsed is the next use of a line that is not accessed again
+const NeverUsed = math.MaxUint64
+
+var policies = map[string]func(sets, ways int) (Policy, error){
+	"lru":    newLRU,
+	"plru":   newTreePLRU,
+	"fifo":   newFIFO,
+	"random": newRandom,
+	"srrip":  newSRRIP,
+	"brrip":  newBRRIP,
+	"drrip":  newDRRIP,
+	"opt":    newOPT,
+}
+
+// Policies returns the names of the available replacement policies
+func Policies() []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newPolicy(name string, sets, ways int) (Policy, error) {
+	create, ok := policies[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown replacement policy %q", name)
+	}
+	return create(sets, ways)
+}
+
+// IsOffline reports whether the named policy needs the future accesses
+func IsOffline(name string) bool {
+	p, err := newPolicy(name, 1, 1)
+	if err != nil {
+		return false
+	}
+	_, ok := p.(OfflinePolicy)
+	return ok
+}
+
+// maxScanWays bounds the associativity of the policies that scan every way of
+// a set to find a victim, larger sets make every miss too slow
+const maxScanWays = 1024
+
+func checkScanWays(name string, ways int) error {
+	if ways > maxScanWays {
+		return fmt.Errorf("Replacement policy %s scans every way to find a victim and supports at most %d ways, got %d ways", name, maxScanWays, ways)
+	}
+	return nil
+}
+
+// lru replaces the least recently used line, the ways of every set form a
+// circular list ordered by recency so hits and victims take constant time
+type lru struct {
+	ways       int
+	prev, next []int32 // Neighbours of every line in the list of its set
+	head       []int32 // Most recently used way of every set
+}
+
+func newLRU(sets, ways int) (Policy, error) {
+	p := &lru{
+		ways: ways,
+		prev: make([]int32, sets*ways),
+		next: make([]int32, sets*ways),
+		head: make([]int32, sets),
+	}
+	for set := 0; set < sets; set++ {
+		for way := 0; way < ways; way++ {
+			p.prev[set*ways+way] = int32((way + ways - 1) % ways)
+			p.next[set*ways+way] = int32((way + 1) % ways)
+		}
+	}
+	return p, nil
+}
+
+func (p *lru) Touch(set, way int) {
+	head := int(p.head[set])
+	if way == head {
+		return
+	}
+	base := set * p.ways
+	prev, next := p.prev[base+way], p.next[base+way]
+	// Unlink the way and insert it before the head, which is the end of the
+	// circular list
+	p.next[base+int(prev)] = next
+	p.prev[base+int(next)] = prev
+	tail := p.prev[base+head]
+	p.next[base+int(tail)] = int32(way)
+	p.prev[base+way] = tail
+	p.next[base+way] = int32(head)
+	p.prev[base+head] = int32(way)
+	p.head[set] = int32(way)
+}
+
+func (p *lru) Insert(set, way int) {
+	p.Touch(set, way)
+}
+
+func (p *lru) Victim(set int) int {
+	return int(p.prev[set*p.ways+int(p.head[set])])
+}
+
+// fifo replaces the line that was inserted first, hits do not change the order
+type fifo struct {
+	lru
+}
+
+func newFIFO(sets, ways int) (Policy, error) {
+	p, err := newLRU(sets, ways)
+	if err != nil {
+		return nil, err
+	}
+	return &fifo{*p.(*lru)}, nil
+}
+
+func (p *fifo) Touch(set, way int) {}
+
+func (p *fifo) Insert(set, way int) {
+	p.lru.Touch(set, way)
+}
+
+// treePLRU approximates LRU with a binary tree per set, every node points to
+// the half that was used less recently
+type treePLRU struct {
+	ways  int
+	depth uint
+	nodes []bool // ways nodes per set, node 0 is unused and node 1 the root
+}
+
+func newTreePLRU(sets, ways int) (Policy, error) {
+	if ways&(ways-1) != 0 {
+		return nil, fmt.Errorf("Replacement policy plru needs a power of two associativity, got %d ways", ways)
+	}
+	depth := uint(0)
+	for 1<<depth < ways {
+		depth++
+	}
+	return &treePLRU{ways: ways, depth: depth, nodes: make([]bool, sets*ways)}, nil
+}
+
+func (p *treePLRU) Touch(set, way int) {
+	nodes := p.nodes[set*p.ways : (set+1)*p.ways]
+	n := 1
+	for i := int(p.depth) - 1; i >= 0; i-- {
+		right := way>>uint(i)&1 == 1
+		// Point away from the accessed way
+		nodes[n] = !right
+		n = 2 * n
+		if right {
+			n++
+		}
+	}
+}
+
+func (p *treePLRU) Insert(set, way int) {
+	p.Touch(set, way)
+}
+
+func (p *treePLRU) Victim(set int) int {
+	nodes := p.nodes[set*p.ways : (set+1)*p.ways]
+	n := 1
+	for n < p.ways {
+		n = 2 * n
+		if nodes[n/2] {
+			n++
+		}
+	}
+	return n - p.ways
+}
+
+// random replaces a random line, the generator is seeded with a constant so
+// runs are reproducible
+type random struct {
+	ways int
+	rand *rand.Rand
+}
+
+func newRandom(sets, ways int) (Policy, error) {
+	return &random{ways: ways, rand: rand.New(rand.NewSource(1))}, nil
+}
+
+func (p *random) Touch(set, way int) {}
+
+func (p *random) Insert(set, way int) {}
+
+func (p *random) Victim(set int) int {
+	return p.rand.Intn(p.ways)
+}
+
+// RRIP policies keep a 2 bit re-reference prediction value per line, hits
+// predict a near re-reference and the victim is a line predicted distant
+const (
+	rrpvBits      = 2
+	rrpvMax       = 1<<rrpvBits - 1
+	brripThrottle = 32 // BRRIP inserts every brripThrottle-th line as SRRIP does
+)
+
+// rrip implements SRRIP and BRRIP, and DRRIP by dueling between the two on a
+// few leader sets
+type rrip struct {
+	ways     int
+	rrpv     []uint8
+	bimodal  bool // Insert lines at the distant prediction (BRRIP)
+	inserts  uint64
+	dueling  bool
+	period   int // Every period-th set leads SRRIP, the one after it BRRIP
+	selector int // Saturating counter, positive if SRRIP leaders miss more
+}
+
+const (
+	duelLeaders = 32  // Leader sets per policy
+	selectorMax = 512 // Bound of the policy selector
+)
+
+func newSRRIP(sets, ways int) (Policy, error) {
+	if err := checkScanWays("srrip", ways); err != nil {
+		return nil, err
+	}
+	return &rrip{ways: ways, rrpv: make([]uint8, sets*ways)}, nil
+}
+
+func newBRRIP(sets, ways int) (Policy, error) {
+	if err := checkScanWays("brrip", ways); err != nil {
+		return nil, err
+	}
+	return &rrip{ways: ways, rrpv: make([]uint8, sets*ways), bimodal: true}, nil
+}
+
+func newDRRIP(sets, ways int) (Policy, error) {
+	if err := checkScanWays("drrip", ways); err != nil {
+		return nil, err
+	}
+	period := sets / duelLeaders
+	if period < 2 {
+		period = 2
+	}
+	return &rrip{ways: ways, rrpv: make([]uint8, sets*ways), dueling: true, period: period}, nil
+}
+
+func (p *rrip) Touch(set, way int) {
+	p.rrpv[set*p.ways+way] = 0
+}
+
+func (p *rrip) Insert(set, way int) {
+	bimodal := p.bimodal
+	if p.dueling {
+		// Inserts are misses, they count against the policy of a leader set
+		switch set % p.period {
+		case 0:
+			if p.selector < selectorMax {
+				p.selector++
+			}
+			bimodal = false
+		case 1:
+			if p.selector > -selectorMax {
+				p.selector--
+			}
+			bimodal = true
+		default:
+			bimodal = p.selector > 0
+		}
+	}
+	rrpv := uint8(rrpvMax - 1)
+	if bimodal {
+		p.inserts++
+		if p.inserts%brripThrottle != 0 {
+			rrpv = rrpvMax
+		}
+	}
+	p.rrpv[set*p.ways+way] = rrpv
+}
+
+func (p *rrip) Victim(set int) int {
+	rrpv := p.rrpv[set*p.ways : (set+1)*p.ways]
+	for {
+		for way, v := range rrpv {
+			if v == rrpvMax {
+				return way
+			}
+		}
+		for way := range rrpv {
+			rrpv[way]++
+		}
+	}
+}
+
+// opt is Belady's optimal policy, it replaces the line that is used again
+// furthest in the future
+type opt struct {
+	ways int
+	next []uint64 // Next use of every line
+	use  uint64   // Next use of the line being accessed
+}
+
+func newOPT(sets, ways int) (Policy, error) {
+	if err := checkScanWays("opt", ways); err != nil {
+		return nil, err
+	}
+	return &opt{ways: ways, next: make([]uint64, sets*ways), use: NeverUsed}, nil
+}
+
+func (p *opt) SetNextUse(next uint64) {
+	p.use = next
+}
+
+func (p *opt) Touch(set, way int) {
+	p.next[set*p.ways+way] = p.use
+}
+
+func (p *opt) Insert(set, way int) {
+	p.Touch(set, way)
+}
+
+func (p *opt) Victim(set int) int {
+	next := p.next[set*p.ways : (set+1)*p.ways]
+	victim := 0
+	for way, n := range next {
+		if n > next[victim] {
+			victim = way
+		}
+	}
+	return victim
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"testing"
+)
+
+const testLineSize = 64
+
+func newTestCache(t *testing.T, policy string, sets, ways int) *Cache {
+	c, err := New(Config{
+		Name:          policy,
+		Size:          uint64(sets*ways) * testLineSize,
+		Ways:          ways,
+		LineSize:      testLineSize,
+		Policy:        policy,
+		WriteBack:     true,
+		WriteAllocate: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// cyclicLines returns rounds repetitions of the lines 0 to n-1
+func cyclicLines(n, rounds int) []uint64 {
+	var lines []uint64
+	for r := 0; r < rounds; r++ {
+		for line := 0; line < n; line++ {
+			lines = append(lines, uint64(line))
+		}
+	}
+	return lines
+}
+
+// beladyHits simulates a fully associative cache of ways lines that replaces
+// the line used again furthest in the future
+func beladyHits(lines []uint64, ways int) uint64 {
+	var cached []uint64
+	hits := uint64(0)
+	nextUse := func(line uint64, after int) int {
+		for i := after + 1; i < len(lines); i++ {
+			if lines[i] == line {
+				return i
+			}
+		}
+		return len(lines)
+	}
+	for i, line := range lines {
+		hit := false
+		for _, c := range cached {
+			if c == line {
+				hit = true
+			}
+		}
+		if hit {
+			hits++
+			continue
+		}
+		if len(cached) < ways {
+			cached = append(cached, line)
+			continue
+		}
+		victim := 0
+		for j, c := range cached {
+			if nextUse(c, i) > nextUse(cached[victim], i) {
+				victim = j
+			}
+		}
+		cached[victim] = line
+	}
+	return hits
+}
+
+// TestCyclicPattern loops over one line more than a set holds, which LRU and
+// FIFO always miss on
+func TestCyclicPattern(t *testing.T) {
+	lines := cyclicLines(5, 100)
+	belady := beladyHits(lines, 4)
+	if belady == 0 {
+		t.Fatal("Reference simulation has no hits")
+	}
+	tests := []struct {
+		policy string
+		hits   uint64
+	}{
+		{"lru", 0},
+		{"fifo", 0},
+		{"opt", belady},
+	}
+	for _, test := range tests {
+		c := newTestCache(t, test.policy, 1, 4)
+		l := NewLookahead(c, len(lines))
+		for _, line := range lines {
+			if c.Offline() {
+				l.Access(line*testLineSize, false)
+			} else {
+				c.Access(line*testLineSize, false)
+			}
+		}
+		l.Flush()
+		if c.Hits() != test.hits {
+			t.Errorf("%s: %d hits, expected %d", test.policy, c.Hits(), test.hits)
+		}
+	}
+}
+
+// TestOPTLookahead checks that a look-ahead buffer wrapping around many times
+// is exact as long as it is deeper than the reuse distance, and that lines
+// reused beyond it are treated as never used again
+func TestOPTLookahead(t *testing.T) {
+	lines := cyclicLines(5, 100)
+	belady := beladyHits(lines, 4)
+	hits := func(depth int) uint64 {
+		c := newTestCache(t, "opt", 1, 4)
+		l := NewLookahead(c, depth)
+		for _, line := range lines {
+			l.Access(line*testLineSize, false)
+		}
+		if l.Queued() != uint64(len(lines)) || l.Simulated() != uint64(len(lines)-depth) {
+			t.Errorf("Depth %d: %d queued and %d simulated before flushing", depth, l.Queued(), l.Simulated())
+		}
+		l.Flush()
+		return c.Hits()
+	}
+	for _, depth := range []int{6, 8, 64, len(lines)} {
+		if h := hits(depth); h != belady {
+			t.Errorf("Depth %d: %d hits, expected %d", depth, h, belady)
+		}
+	}
+	// The next use of every line lies just beyond the buffer
+	if h := hits(5); h == 0 || h >= belady {
+		t.Errorf("Depth 5: %d hits, expected between 0 and %d", h, belady)
+	}
+}
+
+// TestTreePLRU checks a victim that differs from LRU: after filling ways 0-3
+// and touching 0, the root points to the right half, whose node points to way
+// 2 because way 3 was used last
+func TestTreePLRU(t *testing.T) {
+	tests := []struct {
+		policy  string
+		evicted uint64
+	}{
+		{"lru", 1},
+		{"plru", 2},
+	}
+	for _, test := range tests {
+		c := newTestCache(t, test.policy, 1, 4)
+		for _, line := range []uint64{0, 1, 2, 3, 0, 4} {
+			c.Access(line*testLineSize, false)
+		}
+		for line := uint64(0); line <= 4; line++ {
+			if c.Contains(line*testLineSize) == (line == test.evicted) {
+				t.Errorf("%s: line %d present: %v, expected line %d to be evicted", test.policy, line, c.Contains(line*testLineSize), test.evicted)
+			}
+		}
+	}
+
+	if _, err := newTreePLRU(1, 6); err == nil {
+		t.Error("Tree PLRU accepted 6 ways")
+	}
+}
+
+// TestDRRIPDueling checks that misses in the leader sets of one policy make
+// the follower sets use the other one
+func TestDRRIPDueling(t *testing.T) {
+	const sets, ways = 128, 4
+	policy, err := newDRRIP(sets, ways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := policy.(*rrip)
+	if p.period != sets/duelLeaders {
+		t.Fatalf("Period %d, expected %d", p.period, sets/duelLeaders)
+	}
+	follower := 2
+
+	// Misses in the SRRIP leaders favour BRRIP, which inserts at the distant
+	// prediction
+	for i := 0; i < 2*selectorMax; i++ {
+		p.Insert(0, 0)
+	}
+	if p.selector != selectorMax {
+		t.Errorf("Selector %d, expected it to saturate at %d", p.selector, selectorMax)
+	}
+	p.inserts = 0
+	p.Insert(follower, 0)
+	if rrpv := p.rrpv[follower*ways]; rrpv != rrpvMax {
+		t.Errorf("Follower inserted at %d after SRRIP misses, expected %d", rrpv, rrpvMax)
+	}
+
+	// Misses in the BRRIP leaders favour SRRIP
+	for i := 0; i < 2*selectorMax; i++ {
+		p.Insert(1, 0)
+	}
+	if p.selector != -selectorMax {
+		t.Errorf("Selector %d, expected it to saturate at %d", p.selector, -selectorMax)
+	}
+	p.Insert(follower, 1)
+	if rrpv := p.rrpv[follower*ways+1]; rrpv != rrpvMax-1 {
+		t.Errorf("Follower inserted at %d after BRRIP misses, expected %d", rrpv, rrpvMax-1)
+	}
+}
+
+// TestRRIPThrashing loops over a working set larger than the cache, where
+// BRRIP keeps part of it and DRRIP learns to do the same
+func TestRRIPThrashing(t *testing.T) {
+	const sets, ways = 128, 4
+	lines := cyclicLines(sets*ways*3/2, 50)
+	hits := map[string]uint64{}
+	for _, policy := range []string{"srrip", "brrip", "drrip"} {
+		c := newTestCache(t, policy, sets, ways)
+		for _, line := range lines {
+			c.Access(line*testLineSize, false)
+		}
+		hits[policy] = c.Hits()
+	}
+	if hits["brrip"] <= hits["srrip"] {
+		t.Errorf("BRRIP %d hits, SRRIP %d, expected BRRIP to keep part of the loop", hits["brrip"], hits["srrip"])
+	}
+	if hits["drrip"] <= hits["srrip"] {
+		t.Errorf("DRRIP %d hits, SRRIP %d, expected DRRIP to follow BRRIP", hits["drrip"], hits["srrip"])
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/doriandekoning/memory-trace-analyser/cache"
+	pb "github.com/doriandekoning/memory-trace-analyser/proto"
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// cacheConfigs implements flag.Value for repeated cache descriptions
+type cacheConfigs []cache.Config
+
+func (cs *cacheConfigs) String() string {
+	names := make([]string, len(*cs))
+	for i, c := range *cs {
+		names[i] = c.String()
+	}
+	return strings.Join(names, ";")
+}
+
+func (cs *cacheConfigs) Set(value string) error {
+	cfg, err := cache.ParseConfig(value)
+	if err != nil {
+		return err
+	}
+	*cs = append(*cs, cfg)
+	return nil
+}
+
+// levelConfigs implements flag.Value for repeated cache hierarchy levels
+type levelConfigs []cache.LevelConfig
+
+func (ls *levelConfigs) String() string {
+	names := make([]string, len(*ls))
+	for i, l := range *ls {
+		names[i] = l.String()
+	}
+	return strings.Join(names, ";")
+}
+
+func (ls *levelConfigs) Set(value string) error {
+	cfg, err := cache.ParseLevelConfig(value)
+	if err != nil {
+		return err
+	}
+	*ls = append(*ls, cfg)
+	return nil
+}
+
+// simulatedCache is a cache fed with every access and the stats at the end
+// of the previous window
+type simulatedCache struct {
+	*cache.Cache
+	lookahead    *cache.Lookahead // Set for offline policies, delays the accesses
+	window_start cache.Stats
+	pending      []pendingWindow // Closed windows whose accesses are still queued
+}
+
+// pendingWindow is a window that ended at timestamp after end line accesses
+type pendingWindow struct {
+	timestamp uint64
+	end       uint64
+}
+
+func (c *simulatedCache) add(a trace.Access) {
+	if c.lookahead != nil {
+		c.lookahead.AccessRange(a.Addr, uint64(a.Size), a.Kind.IsWrite())
+		return
+	}
+	c.AccessRange(a.Addr, uint64(a.Size), a.Kind.IsWrite())
+}
+
+var cacheCSVHeader = []string{"timestamp", "cache", "policy", "accesses", "hits", "misses", "read_misses", "write_misses", "evictions", "writebacks", "write_throughs", "miss_ratio"}
+
+func cacheCSVRecord(timestamp uint64, name, policy string, s cache.Stats) []string {
+	return []string{
+		strconv.FormatUint(timestamp, 10),
+		name,
+		policy,
+		strconv.FormatUint(s.Accesses(), 10),
+		strconv.FormatUint(s.Hits(), 10),
+		strconv.FormatUint(s.Misses(), 10),
+		strconv.FormatUint(s.ReadMisses, 10),
+		strconv.FormatUint(s.WriteMisses, 10),
+		strconv.FormatUint(s.Evictions, 10),
+		strconv.FormatUint(s.Writebacks, 10),
+		strconv.FormatUint(s.WriteThroughs, 10),
+		strconv.FormatFloat(s.MissRatio(), 'f', 6, 64),
+	}
+}
+
+// writeOutCaches writes the statistics of every simulated cache during the
+// window ending at timestamp
+func (s *Stats) writeOutCaches(timestamp uint64) {
+	for _, c := range s.caches {
+		if c.lookahead != nil {
+			// The window is written once its queued accesses are simulated
+			c.pending = append(c.pending, pendingWindow{timestamp: timestamp, end: c.lookahead.Queued()})
+			continue
+		}
+		s.writeOutCache(c, timestamp)
+	}
+	if s.hierarchy == nil {
+		return
+	}
+	for _, c := range s.hierarchy.Caches() {
+		if s.cacheCsvWriter != nil {
+			s.cacheCsvWriter.Write(cacheCSVRecord(timestamp, c.Name, c.Policy, c.Stats.Sub(s.hierarchy.window_start[c.Cache])))
+		}
+		s.hierarchy.window_start[c.Cache] = c.Stats
+	}
+}
+
+func (s *Stats) writeOutCache(c *simulatedCache, timestamp uint64) {
+	if s.cacheCsvWriter != nil {
+		s.cacheCsvWriter.Write(cacheCSVRecord(timestamp, c.String(), c.Policy, c.Stats.Sub(c.window_start)))
+	}
+	c.window_start = c.Stats
+}
+
+// writeOutPending writes the pending windows of c whose accesses have all
+// been simulated
+func (s *Stats) writeOutPending(c *simulatedCache) {
+	for len(c.pending) > 0 && c.lookahead.Simulated() >= c.pending[0].end {
+		s.writeOutCache(c, c.pending[0].timestamp)
+		c.pending = c.pending[1:]
+	}
+}
+
+// flushCaches simulates the accesses still queued for offline policies
+func (s *Stats) flushCaches() {
+	for _, c := range s.caches {
+		if c.lookahead == nil {
+			continue
+		}
+		for len(c.pending) > 0 {
+			c.lookahead.Drain(c.pending[0].end)
+			s.writeOutPending(c)
+		}
+		c.lookahead.Flush()
+	}
+}
+
+func (s *Stats) printCaches() {
+	for _, c := range s.caches {
+		log.Printf("Cache %s:\thits: %d\tmisses: %d\tevictions: %d\twritebacks: %d\tmiss ratio: %f\n", c, c.Hits(), c.Misses(), c.Evictions, c.Writebacks, c.MissRatio())
+	}
+	if s.hierarchy == nil {
+		return
+	}
+	for _, c := range s.hierarchy.Caches() {
+		log.Printf("Cache %s:\thits: %d\tmisses: %d\tevictions: %d\twritebacks: %d\tinvalidations: %d\tmiss ratio: %f\n", c.Name, c.Hits(), c.Misses(), c.Evictions, c.Writebacks, c.Invalidations, c.MissRatio())
+	}
+	log.Printf("Memory:\treads: %d\twrites: %d\n", s.hierarchy.MemoryReads, s.hierarchy.MemoryWrites)
+}
+
+// addCaches creates a simulated cache for every configuration, and every
+// replacement policy if any are given so they can be compared on the same
+// accesses. Offline policies see the accesses lookahead line accesses late.
+func (s *Stats) addCaches(cfgs []cache.Config, policies []string, lookahead int) error {
+	for _, cfg := range cfgs {
+		variants := []cache.Config{cfg}
+		if len(policies) > 0 {
+			variants = variants[:0]
+			for _, policy := range policies {
+				variant := cfg
+				variant.Policy = policy
+				if cfg.Name != "" {
+					variant.Name = cfg.Name + "-" + policy
+				}
+				variants = append(variants, variant)
+			}
+		}
+		for _, variant := range variants {
+			c, err := cache.New(variant)
+			if err != nil {
+				return err
+			}
+			sim := &simulatedCache{Cache: c}
+			if c.Offline() {
+				sim.lookahead = cache.NewLookahead(c, lookahead)
+			}
+			s.caches = append(s.caches, sim)
+		}
+	}
+	return nil
+}
+
+// simulatedHierarchy is a cache hierarchy fed with every access, the traffic
+// to memory is optionally written as a gem5 trace
+type simulatedHierarchy struct {
+	*cache.Hierarchy
+	window_start map[*cache.Cache]cache.Stats
+	commands     *trace.CommandTable
+	tick_freq    uint64    // Tick frequency of the input, written to the trace header
+	trace_file   io.Writer // Memory trace output, nil if disabled
+	trace        *trace.Gem5Writer
+	access       trace.Access // Access being simulated
+	err          error        // First error writing the memory trace
+	// Write the time of the accesses instead of their ticks, used when the
+	// inputs have different tick frequencies
+	nanoseconds bool
+}
+
+// setHierarchy creates the hierarchy described by cfgs, the memory traffic is
+// written to traceFile unless it is nil
+func (s *Stats) setHierarchy(cfgs []cache.LevelConfig, commands *trace.CommandTable, traceFile io.Writer) error {
+	h, err := cache.NewHierarchy(cfgs)
+	if err != nil {
+		return err
+	}
+	s.hierarchy = &simulatedHierarchy{
+		Hierarchy:    h,
+		window_start: map[*cache.Cache]cache.Stats{},
+		commands:     commands,
+		trace_file:   traceFile,
+	}
+	if traceFile != nil {
+		h.OnMemory = s.hierarchy.writePacket
+	}
+	return nil
+}
+
+func (h *simulatedHierarchy) add(a trace.Access) {
+	h.access = a
+	h.Access(a)
+}
+
+// openTrace writes the header of the memory trace
+func (h *simulatedHierarchy) openTrace() error {
+	tickFreq := h.tick_freq
+	if tickFreq == 0 {
+		// Ticks of gem5 traces are picoseconds by default
+		tickFreq = 1000000000000
+	}
+	objID := "memory"
+	var err error
+	h.trace, err = trace.NewGem5Writer(h.trace_file, &pb.PacketHeader{
+		TickFreq: &tickFreq,
+		ObjId:    &objID,
+	})
+	return err
+}
+
+// writePacket writes a request to memory caused by the current access
+func (h *simulatedHierarchy) writePacket(cmd trace.Command, addr uint64) {
+	if h.err != nil {
+		return
+	}
+	if h.trace == nil {
+		if h.err = h.openTrace(); h.err != nil {
+			return
+		}
+	}
+	tick, pc := h.access.Tick, h.access.PC
+	if h.nanoseconds {
+		tick = h.access.Time
+	}
+	encoded := h.commands.Encode(cmd)
+	size := uint32(h.LineSize())
+	packet := pb.Packet{
+		Tick: &tick,
+		Addr: &addr,
+		Cmd:  &encoded,
+		Size: &size,
+	}
+	if pc != 0 {
+		packet.Pc = &pc
+	}
+	if h.access.Kind == trace.Fetch && cmd == trace.ReadCleanReq {
+		// gem5 copies the request flags onto the packets of a miss
+		flags := uint32(trace.FlagInstFetch)
+		packet.Flags = &flags
+	}
+	h.err = h.trace.WritePacket(&packet)
+}
+
+// closeTrace flushes the memory trace, it also writes the header if no request
+// reached memory
+func (h *simulatedHierarchy) closeTrace() error {
+	if h.trace_file == nil {
+		return nil
+	}
+	if h.trace == nil && h.err == nil {
+		h.err = h.openTrace()
+	}
+	if h.err != nil {
+		return h.err
+	}
+	return h.trace.Flush()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/doriandekoning/memory-trace-analyser/cache"
+)
+
+// granularity is the power of two block size addresses are grouped by, e.g.
+// a cache line or a (huge) page
+type granularity struct {
+	shift uint
+}
+
+var pageGranularity = granularity{shift: 12}
+
+// parseGranularity parses a block size such as 64B, 4KiB, 2M or 1073741824
+func parseGranularity(s string) (granularity, error) {
+	size, err := cache.ParseSize(s)
+	if err != nil || size == 0 {
+		return granularity{}, fmt.Errorf("Invalid granularity: %q", s)
+	}
+	if bits.OnesCount64(size) != 1 {
+		return granularity{}, fmt.Errorf("Granularity is not a power of two: %q", s)
+	}
+	return granularity{shift: uint(bits.TrailingZeros64(size))}, nil
+}
+
+// block returns the number of the block containing addr
+func (g granularity) block(addr uint64) uint64 {
+	return addr >> g.shift
+}
+
+// addr returns the start address of block
+func (g granularity) addr(block uint64) uint64 {
+	return block << g.shift
+}
+
+func (g granularity) String() string {
+	return cache.FormatSize(1 << g.shift)
+}
+
+// columnSuffix is appended to the names of the CSV columns of g, 4KiB pages
+// keep the plain column names
+func (g granularity) columnSuffix() string {
+	if g == pageGranularity {
+		return ""
+	}
+	return "_" + g.String()
+}
+
+// granularities implements flag.Value for a comma separated list of block
+// sizes
+type granularities []granularity
+
+func (gs *granularities) String() string {
+	names := make([]string, len(*gs))
+	for i, g := range *gs {
+		names[i] = g.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (gs *granularities) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		g, err := parseGranularity(s)
+		if err != nil {
+			return err
+		}
+		for _, existing := range *gs {
+			if existing == g {
+				return fmt.Errorf("Granularity %s given twice", g)
+			}
+		}
+		*gs = append(*gs, g)
+	}
+	return nil
+}
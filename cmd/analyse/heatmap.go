@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// heatmap records the accesses per page of every window. The accessed
+// address range is only known at the end of the trace, so the pages are
+// grouped into address buckets when the heatmap is written. Once the windows
+// hold more than limit page counts the pages are doubled in size, which
+// merges neighbouring pages, until they fit again.
+type heatmap struct {
+	granularity
+	current map[uint64]*accessCounts // Pages accessed in the current window
+	windows []heatmapWindow
+	stored  int // Page counts held by the windows
+	limit   int
+}
+
+type heatmapWindow struct {
+	timestamp uint64
+	pages     []pageCounts
+}
+
+type pageCounts struct {
+	page uint64
+	accessCounts
+}
+
+func newHeatmap(g granularity, limit int) *heatmap {
+	return &heatmap{granularity: g, current: map[uint64]*accessCounts{}, limit: limit}
+}
+
+func (h *heatmap) add(addr uint64, kind trace.Kind) {
+	page := h.block(addr)
+	c := h.current[page]
+	if c == nil {
+		c = &accessCounts{}
+		h.current[page] = c
+	}
+	c.add(kind)
+}
+
+// closeWindow stores the counts of the current window ending at timestamp
+func (h *heatmap) closeWindow(timestamp uint64) {
+	w := heatmapWindow{timestamp: timestamp, pages: make([]pageCounts, 0, len(h.current))}
+	for page, c := range h.current {
+		w.pages = append(w.pages, pageCounts{page: page, accessCounts: *c})
+	}
+	h.windows = append(h.windows, w)
+	h.current = map[uint64]*accessCounts{}
+	h.stored += len(w.pages)
+	// Every window keeps at least one page, so more windows than the limit
+	// can not be merged into it
+	for h.limit > 0 && h.stored > h.limit && h.stored > len(h.windows) && h.shift < 63 {
+		h.coarsen()
+	}
+}
+
+// coarsen doubles the page size, merging the counts of neighbouring pages
+func (h *heatmap) coarsen() {
+	h.shift++
+	h.stored = 0
+	for i, w := range h.windows {
+		merged := map[uint64]*accessCounts{}
+		for _, p := range w.pages {
+			c := merged[p.page>>1]
+			if c == nil {
+				c = &accessCounts{}
+				merged[p.page>>1] = c
+			}
+			c.total_reads += p.total_reads
+			c.total_writes += p.total_writes
+			c.total_fetch += p.total_fetch
+		}
+		pages := w.pages[:0]
+		for page, c := range merged {
+			pages = append(pages, pageCounts{page: page, accessCounts: *c})
+		}
+		h.windows[i].pages = pages
+		h.stored += len(pages)
+	}
+}
+
+// heatmapGrid is a heatmap grouped into equally sized address buckets
+type heatmapGrid struct {
+	times       []uint64 // End of every window
+	start       uint64   // Address of the first bucket
+	bucket_size uint64
+	counts      [][]accessCounts // Indexed by window and bucket
+	reset       bool             // The counts are per window instead of accumulated
+}
+
+// bucketAddr returns the start address of bucket
+func (g *heatmapGrid) bucketAddr(bucket int) uint64 {
+	return g.start + uint64(bucket)*g.bucket_size
+}
+
+// grid groups the pages between minAddr and maxAddr into at most buckets
+// buckets of whole pages. The counts accumulate over the windows unless
+// reset is set.
+func (h *heatmap) grid(minAddr, maxAddr uint64, buckets int, reset bool) *heatmapGrid {
+	minPage, maxPage := h.block(minAddr), h.block(maxAddr)
+	pagesPerBucket := (maxPage-minPage)/uint64(buckets) + 1
+	g := &heatmapGrid{
+		start:       h.addr(minPage),
+		bucket_size: h.addr(pagesPerBucket),
+		reset:       reset,
+	}
+	n := int((maxPage-minPage)/pagesPerBucket) + 1
+	counts := make([]accessCounts, n)
+	for _, w := range h.windows {
+		if reset {
+			counts = make([]accessCounts, n)
+		}
+		for _, p := range w.pages {
+			c := &counts[(p.page-minPage)/pagesPerBucket]
+			c.total_reads += p.total_reads
+			c.total_writes += p.total_writes
+			c.total_fetch += p.total_fetch
+		}
+		g.times = append(g.times, w.timestamp)
+		g.counts = append(g.counts, append([]accessCounts(nil), counts...))
+	}
+	return g
+}
+
+// mergeWindows returns g with consecutive windows merged so there are at most
+// windows left, merged windows end with the last window of their group
+func (g *heatmapGrid) mergeWindows(windows int) *heatmapGrid {
+	if len(g.counts) <= windows {
+		return g
+	}
+	merged := &heatmapGrid{start: g.start, bucket_size: g.bucket_size, reset: g.reset}
+	for i := 0; i < windows; i++ {
+		first, last := i*len(g.counts)/windows, (i+1)*len(g.counts)/windows-1
+		// Accumulated counts already include the earlier windows
+		counts := append([]accessCounts(nil), g.counts[last]...)
+		if g.reset {
+			for _, window := range g.counts[first:last] {
+				for bucket, c := range window {
+					counts[bucket].total_reads += c.total_reads
+					counts[bucket].total_writes += c.total_writes
+					counts[bucket].total_fetch += c.total_fetch
+				}
+			}
+		}
+		merged.times = append(merged.times, g.times[last])
+		merged.counts = append(merged.counts, counts)
+	}
+	return merged
+}
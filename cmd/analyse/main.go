@@ -0,0 +1,521 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/doriandekoning/memory-trace-analyser/cache"
+	pb "github.com/doriandekoning/memory-trace-analyser/proto"
+	"github.com/doriandekoning/memory-trace-analyser/reuse"
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+func main() {
+	var inputs trace.InputSpecs
+	flag.Var(&inputs, "input", "Comma separated input files or a single input description 'path=<file>,cpu=<id>,role=<data|ifetch|miss>', may be repeated. gem5 inputs without cpu or role alternate between the data and instruction stream of every CPU")
+	inputManifest := flag.String("inputmanifest", "", "File listing one input description per line")
+	outputFile := flag.String("output", "output.csv", "Statistics output with a row per window, timestamps are in nanoseconds")
+	heatmapOutputFile := flag.String("heatmapoutput", "", "Heatmap output with the reads, writes and fetches per address bucket of every window")
+	heatmapImageFile := flag.String("heatmapimage", "", "Render the heatmap to a .png or .svg image with log scaled writes in red, reads in green and fetches in blue")
+	heatmapBuckets := flag.Int("heatmapbuckets", 256, "Maximum amount of address buckets the accessed address range is divided in")
+	heatmapReset := flag.Bool("heatmapreset", false, "If set to true the heatmap counts are reset every window instead of accumulated")
+	heatmapPages := flag.Int("heatmappages", 1<<22, "Maximum amount of page counts the heatmap keeps over all windows, every window keeps a count of 32 bytes per accessed page. Above it the pages are doubled in size until the counts fit, 0 means no limit")
+	inputSource := flag.String("inputsource", "", "Input source gem5/qemu")
+	gemTraceOut := flag.String("gemtraceout", "", "Gem trace ouput location for gem trace")
+	qemuLayout := flag.String("qemulayout", "qemu", "Record layout of QEMU traces without header (qemu/converter/full)")
+	memmapFile := flag.String("memmap", "", "Memory map file, either a QEMU 'info mtree' dump or '<name> <start> <end>' lines (default: QEMU pc RAM layout for qemu traces, all memory for gem5 traces)")
+	cpuOutputFile := flag.String("cpuoutput", "", "Per CPU statistics output")
+	pcOutputFile := flag.String("pcoutput", "", "Per program counter access and miss output")
+	regionOutputFile := flag.String("regionoutput", "", "Per memory region statistics output")
+	sizeOutputFile := flag.String("sizeoutput", "", "Per access size statistics output")
+	windowSize := flag.Uint64("window", 10000000, "Write a row of statistics every given amount of accesses, 0 disables access windows. Not used when -windowtime or -windowinstructions is given")
+	windowInstructions := flag.Uint64("windowinstructions", 0, "Write a row of statistics every given amount of instructions instead of every -window accesses")
+	instructionSource := flag.String("instructions", "fetch", "Instruction count source (none/fetch/pc/input) for the per kilo instruction columns, none leaves them out, fetch counts the fetch records, pc the program counter changes of every CPU and input reads -instructioninput")
+	instructionInput := flag.String("instructioninput", "", "File with '<tick>,<instructions>' lines giving the total amount of retired instructions at a tick, implies -instructions input")
+	windowTime := flag.Duration("windowtime", 0, "Write a row of statistics every given amount of trace time, e.g. 1ms, instead of every -window accesses")
+	reuseOutputFile := flag.String("reuseoutput", "", "Stack distance histogram output")
+	mrcOutputFile := flag.String("mrcoutput", "", "Miss ratio curve output of a fully associative LRU cache, derived from the stack distances")
+	reuseGrans := granularities{}
+	flag.Var(&reuseGrans, "reusegranularity", "Comma separated block sizes to compute the stack distances for (default 64B,4KiB)")
+	reuseRate := flag.Float64("reuserate", 1, "Fraction of the blocks sampled for the stack distances (SHARDS), lower rates use less memory and time but are only accurate for caches much larger than 1/rate blocks")
+	var caches cacheConfigs
+	flag.Var(&caches, "cache", fmt.Sprintf("Simulate a cache described as 'name=<name>,size=<bytes>,ways=<ways, 0 is fully associative>,line=<bytes>,policy=<%s>,write=<back|through>,allocate=<true|false>', may be repeated", strings.Join(cache.Policies(), "|")))
+	cachePolicies := flag.String("cachepolicies", "", fmt.Sprintf("Comma separated replacement policies (%s) every -cache is simulated with, to compare them on the same accesses", strings.Join(cache.Policies(), "/")))
+	optLookahead := flag.Int("optlookahead", 1<<20, "Line accesses the opt policy looks ahead, lines not used again within them count as never used again")
+	var levels levelConfigs
+	flag.Var(&levels, "level", "Add a cache to the simulated hierarchy, described like -cache with the additional attributes 'level=<1..>,type=<unified|inst|data>,shared=<true|false>,inclusion=<noninclusive|inclusive|exclusive>', may be repeated. Private levels have a cache per CPU and fetches use the instruction caches")
+	memTraceOut := flag.String("memtraceout", "", "Write the requests leaving the last level of the hierarchy as a gem5 trace")
+	cacheOutputFile := flag.String("cacheoutput", "", "Per window statistics of the simulated caches")
+	validate := flag.Bool("validate", false, "If set to true the requests the -level hierarchy sends to memory are compared with the miss stream inputs (role=miss) per window")
+	validationOutputFile := flag.String("validationoutput", "", "Per window agreement between the simulated and recorded misses and writebacks, implies -validate")
+	approximate := flag.Bool("approximate", false, "If set to true the page counts are estimated in a fixed amount of memory using a count-min sketch and HyperLogLog, the heatmap and per program counter outputs are not available")
+	var grans granularities
+	flag.Var(&grans, "granularity", "Comma separated block sizes to compute the footprint for, e.g. 64B,4KiB,2MiB, may be repeated. The first is used for the per CPU and region pages (default 4KiB)")
+	onError := flag.String("on-error", "stop", "Handling of damaged trace records (stop/skip/resync)")
+	maxMessageSize := flag.Int("maxmessagesize", trace.DefaultMaxMessageSize, "Largest gem5 packet in bytes that is accepted")
+	skip := flag.Uint64("skip", 0, "Amount of accesses to skip at the start of the merged inputs")
+	limit := flag.Uint64("limit", 0, "Maximum amount of accesses read from the merged inputs after skipping, 0 means no limit")
+	startTick := flag.Uint64("start-tick", 0, "Ignore accesses before this tick")
+	endTick := flag.Uint64("end-tick", 0, "Stop reading an input at the first access at or after this tick, 0 means no end")
+	gem5Version := flag.String("gem5version", trace.DefaultGem5Version, fmt.Sprintf("gem5 version used to number the commands (%s)", strings.Join(trace.Gem5Versions(), "/")))
+	// amountCpus := flag.Int("cpus", 1, "Amount of simulated cpus")
+	flag.BoolVar(&debuggingEnabled, "debug", false, "If set to true additional debugging info will be logged")
+	flag.Parse()
+	if *inputManifest != "" {
+		if err := inputs.ReadManifest(*inputManifest); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(inputs) == 0 {
+		log.Fatal("No input given")
+	}
+	inputs.Resolve(trace.SplitCacheLayout)
+	var opts trace.Options
+	var err error
+	opts.Commands, err = trace.Gem5Commands(*gem5Version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.OnError, err = trace.ParseErrorMode(*onError)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.MaxMessageSize = *maxMessageSize
+	opts.Skip, opts.Limit = *skip, *limit
+	opts.StartTick, opts.EndTick = *startTick, *endTick
+	if opts.EndTick != 0 && opts.EndTick <= opts.StartTick {
+		log.Fatal("The end tick has to be after the start tick")
+	}
+
+	var memmap *trace.MemoryMap
+	if *memmapFile != "" {
+		memmap, err = trace.ReadMemoryMap(*memmapFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if *inputSource == "qemu" {
+		memmap = trace.QemuPCMemoryMap
+	} else {
+		memmap = trace.FullMemoryMap()
+	}
+	for _, r := range memmap.Regions {
+		Debugf("Region %s: %x-%x", r.Name, r.Start, r.End)
+	}
+
+	file, err := os.Create(*outputFile)
+	if err != nil {
+		log.Fatal("Unable to open input: ", err)
+	}
+
+	log.Print("Writing output to:", *outputFile)
+	defer file.Close()
+
+	var gem5Out io.Writer
+	log.Println("GEMOUT: ", *gemTraceOut)
+	if gemTraceOut != nil && *gemTraceOut != "" {
+		gemOutFile, err := os.Create(*gemTraceOut)
+		if err != nil {
+			log.Fatal("Unable to open qemu trace output file: ", err)
+		}
+		defer gemOutFile.Close()
+		gem5Out = gemOutFile
+	}
+	outWriter := csv.NewWriter(file)
+	stats := newStats(outWriter, memmap, grans, *approximate)
+	stats.window_size = *windowSize
+	stats.window_interval = uint64(windowTime.Nanoseconds())
+	source, err := trace.ParseInstructionSource(*instructionSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *instructionInput != "" {
+		source = trace.InputInstructions
+	}
+	if source != trace.NoInstructions {
+		stats.instructions, err = trace.OpenInstructionCounter(source, *instructionInput)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if *windowInstructions > 0 {
+		log.Fatal("Instruction windows need an instruction source, -instructions can not be none")
+	}
+	stats.window_instructions = *windowInstructions
+	if stats.window_interval > 0 && stats.window_instructions > 0 {
+		log.Fatal("Only one of -windowtime and -windowinstructions can be given")
+	}
+	if stats.window_interval > 0 || stats.window_instructions > 0 {
+		// Time and instruction windows replace the default access windows
+		if flagSet("window") && stats.window_size > 0 {
+			log.Fatal("Only one of -window, -windowtime and -windowinstructions can be given")
+		}
+		stats.window_size = 0
+	}
+	var policies []string
+	if *cachePolicies != "" {
+		policies = strings.Split(*cachePolicies, ",")
+	}
+	if err := stats.addCaches(caches, policies, *optLookahead); err != nil {
+		log.Fatal(err)
+	}
+	if len(levels) > 0 {
+		var memTraceFile io.Writer
+		if *memTraceOut != "" {
+			f, err := os.Create(*memTraceOut)
+			if err != nil {
+				log.Fatal("Unable to open memory trace output: ", err)
+			}
+			defer f.Close()
+			memTraceFile = f
+		}
+		if err := stats.setHierarchy(levels, opts.Commands, memTraceFile); err != nil {
+			log.Fatal(err)
+		}
+	} else if *memTraceOut != "" {
+		log.Fatal("A memory trace needs a cache hierarchy, add levels with -level")
+	}
+	if *validate || *validationOutputFile != "" {
+		if *inputSource != "gem5" {
+			log.Fatal("Validation needs a gem5 miss stream input")
+		}
+		var validationWriter *csv.Writer
+		if *validationOutputFile != "" {
+			validationFile, err := os.Create(*validationOutputFile)
+			if err != nil {
+				log.Fatal("Unable to open validation output: ", err)
+			}
+			defer validationFile.Close()
+			validationWriter = csv.NewWriter(validationFile)
+			validationWriter.Write(validationCSVHeader)
+		}
+		if err := stats.startValidation(validationWriter); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *cacheOutputFile != "" {
+		cacheFile, err := os.Create(*cacheOutputFile)
+		if err != nil {
+			log.Fatal("Unable to open cache output: ", err)
+		}
+		defer cacheFile.Close()
+		stats.cacheCsvWriter = csv.NewWriter(cacheFile)
+		stats.cacheCsvWriter.Write(cacheCSVHeader)
+	}
+	if *reuseOutputFile != "" || *mrcOutputFile != "" {
+		if len(reuseGrans) == 0 {
+			reuseGrans = granularities{{shift: 6}, pageGranularity}
+		}
+		if *reuseRate <= 0 || *reuseRate > 1 {
+			log.Fatal("The reuse sampling rate has to be in (0, 1]")
+		}
+		for _, g := range reuseGrans {
+			stats.reuse = append(stats.reuse, reuse.New(g.shift, *reuseRate))
+		}
+	}
+	if *heatmapImageFile != "" {
+		if err := checkImageFormat(*heatmapImageFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *approximate && (*heatmapOutputFile != "" || *heatmapImageFile != "" || *pcOutputFile != "") {
+		log.Fatal("The heatmap and per program counter outputs keep exact counts and can not be combined with -approximate")
+	}
+	if *pcOutputFile != "" {
+		stats.pcs = map[uint64]*pcStats{}
+	}
+	if *heatmapOutputFile != "" || *heatmapImageFile != "" {
+		if *heatmapBuckets <= 0 {
+			log.Fatal("The amount of heatmap buckets has to be positive")
+		}
+		if *heatmapPages < 0 {
+			log.Fatal("The amount of heatmap pages can not be negative")
+		}
+		stats.heatmap = newHeatmap(stats.pages().granularity, *heatmapPages)
+	}
+	if *cpuOutputFile != "" {
+		cpuFile, err := os.Create(*cpuOutputFile)
+		if err != nil {
+			log.Fatal("Unable to open cpu output: ", err)
+		}
+		defer cpuFile.Close()
+		stats.cpuCsvWriter = csv.NewWriter(cpuFile)
+		stats.cpuCsvWriter.Write(stats.cpuCSVHeader())
+	}
+	header := stats.csvHeader()
+	stats.csvWriter.Write(header)
+	zeros := make([]string, len(header))
+	for i := range zeros {
+		zeros[i] = "0"
+	}
+	stats.csvWriter.Write(zeros)
+
+	Debugf("Using inputs: '%v' for inputsource: %s", inputs.String(), *inputSource)
+	if *inputSource == "qemu" {
+		log.Printf("Reading qemu trace")
+		layout, err := trace.ParseQemuLayout(*qemuLayout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fallback := trace.LegacyQemuHeader
+		fallback.Layout = layout
+		processQemuTrace(inputs[0].Path, fallback, opts, stats, gem5Out)
+	} else if *inputSource == "gem5" {
+		log.Printf("Reading gem5 trace")
+		processGem5Trace(inputs, opts, stats)
+	} else {
+		log.Fatal("Unknown input source")
+	}
+	stats.printRegions()
+	stats.printBreakdowns()
+	stats.printCaches()
+	if stats.validation != nil {
+		stats.validation.print()
+	}
+	if stats.hierarchy != nil {
+		if err := stats.hierarchy.closeTrace(); err != nil {
+			log.Fatal("Unable to write memory trace: ", err)
+		}
+	}
+
+	if *pcOutputFile != "" {
+		pcFile, err := os.Create(*pcOutputFile)
+		if err != nil {
+			log.Fatal("Unable to open pc output: ", err)
+		}
+		defer pcFile.Close()
+		if err := stats.writePCsCSV(csv.NewWriter(pcFile)); err != nil {
+			log.Fatal("Unable to write pc output: ", err)
+		}
+	}
+
+	if *heatmapOutputFile != "" {
+		heatmapFile, err := os.Create(*heatmapOutputFile)
+		if err != nil {
+			log.Fatal("Unable to open heatmap output: ", err)
+		}
+		defer heatmapFile.Close()
+		if err := stats.outputHeatmapCSV(csv.NewWriter(heatmapFile), *heatmapBuckets, *heatmapReset); err != nil {
+			log.Fatal("Unable to write heatmap output: ", err)
+		}
+	}
+
+	if *heatmapImageFile != "" {
+		if err := stats.writeHeatmapImage(*heatmapImageFile, *heatmapBuckets, *heatmapReset); err != nil {
+			log.Fatal("Unable to write heatmap image: ", err)
+		}
+	}
+
+	if *reuseOutputFile != "" || *mrcOutputFile != "" {
+		var histWriter, mrcWriter *csv.Writer
+		if *reuseOutputFile != "" {
+			reuseFile, err := os.Create(*reuseOutputFile)
+			if err != nil {
+				log.Fatal("Unable to open reuse output: ", err)
+			}
+			defer reuseFile.Close()
+			histWriter = csv.NewWriter(reuseFile)
+		}
+		if *mrcOutputFile != "" {
+			mrcFile, err := os.Create(*mrcOutputFile)
+			if err != nil {
+				log.Fatal("Unable to open miss ratio curve output: ", err)
+			}
+			defer mrcFile.Close()
+			mrcWriter = csv.NewWriter(mrcFile)
+		}
+		if err := stats.writeReuseCSVs(histWriter, mrcWriter); err != nil {
+			log.Fatal("Unable to write reuse output: ", err)
+		}
+	}
+
+	if *regionOutputFile != "" {
+		regionFile, err := os.Create(*regionOutputFile)
+		if err != nil {
+			log.Fatal("Unable to open region output: ", err)
+		}
+		defer regionFile.Close()
+		if err := stats.writeRegionsCSV(csv.NewWriter(regionFile)); err != nil {
+			log.Fatal("Unable to write region output: ", err)
+		}
+	}
+
+	if *sizeOutputFile != "" {
+		sizeFile, err := os.Create(*sizeOutputFile)
+		if err != nil {
+			log.Fatal("Unable to open size output: ", err)
+		}
+		defer sizeFile.Close()
+		if err := stats.writeSizesCSV(csv.NewWriter(sizeFile)); err != nil {
+			log.Fatal("Unable to write size output: ", err)
+		}
+	}
+}
+
+func processGem5Trace(specs trace.InputSpecs, opts trace.Options, stats *Stats) {
+	inputs := []trace.Reader{}
+	var tickFreq uint64
+	mixedFreqs := false
+	// Names of the requestors of every input, gem5 stores the requestor id as
+	// packet id
+	requestors := make([]map[uint32]string, len(specs))
+	for _, spec := range specs {
+		in, err := trace.OpenGem5(spec.Path, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer in.Close()
+		defer reportSkipped(spec.Path, in)
+		inputs = append(inputs, in)
+
+		log.Println("TRACEHEADER:", in.Header)
+		log.Println("Version:", in.Header.GetVer())
+		log.Println("Tick frequency:", in.Header.GetTickFreq())
+		if len(inputs) > 1 && tickFreq != in.Header.GetTickFreq() {
+			mixedFreqs = true
+		}
+		tickFreq = in.Header.GetTickFreq()
+		log.Println("Objid:", in.Header.GetObjId())
+		requestors[len(inputs)-1] = in.IDStrings()
+		for id, name := range requestors[len(inputs)-1] {
+			log.Printf("Id %d: %s\n", id, name)
+		}
+		if spec.Role != trace.RoleMiss {
+			stats.labelCPU(spec.CPU, in.Header.GetObjId())
+		}
+	}
+	if mixedFreqs && (opts.StartTick != 0 || opts.EndTick != 0) {
+		log.Fatal("Inputs have different tick frequencies, -start-tick and -end-tick are ambiguous")
+	}
+	if stats.hierarchy != nil {
+		stats.hierarchy.tick_freq = tickFreq
+		if mixedFreqs {
+			// Write nanoseconds so all requests share the same time base
+			log.Println("Inputs have different tick frequencies, the memory trace uses nanoseconds")
+			stats.hierarchy.tick_freq = 1e9
+			stats.hierarchy.nanoseconds = true
+		}
+	}
+	merger := trace.Select(trace.NewMerger(inputs...), opts)
+
+	var startTime uint64
+	started := false
+	for {
+		a, err := merger.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Unable to get next packet:", err)
+			}
+			break
+		}
+		if !started {
+			// The first access may lie at time zero
+			startTime, started = a.Time, true
+		}
+		a.Time -= startTime
+		specs[a.Input].Apply(&a)
+		if names := requestors[a.Input]; len(names) > 0 && !a.Miss {
+			if name, ok := names[uint32(a.PktID)]; ok {
+				stats.labelRequestor(a.CPU, a.PktID, name)
+			}
+		}
+		stats.processAccess(a)
+	}
+	stats.flush()
+	stats.print()
+}
+
+// processQemuTrace analyses the QEMU trace at path, the accesses are also
+// converted to a gem5 trace written to gemOutFile unless it is nil
+func processQemuTrace(path string, fallback trace.QemuHeader, opts trace.Options, stats *Stats, gemOutFile io.Writer) {
+	in, err := trace.OpenQemu(path, fallback, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	if in.Header.Version == 0 {
+		log.Println("Trace has no header, assuming layout:", in.Header.Layout)
+	} else {
+		log.Printf("Trace version: %d, layout: %s, cpus: %d, tick frequency: %d\n", in.Header.Version, in.Header.Layout, in.Header.CPUs, in.Header.TickFreq)
+	}
+	if stats.hierarchy != nil {
+		stats.hierarchy.tick_freq = in.Header.TickFreq
+	}
+	var gemOut *trace.Gem5Writer
+	if gemOutFile != nil {
+		tickFreq := in.Header.TickFreq
+		if tickFreq == 0 {
+			// Legacy traces do not record the frequency, assume picoseconds
+			tickFreq = 1000000000000
+		}
+		objId := "objid"
+		header := pb.PacketHeader{
+			TickFreq: &tickFreq,
+			ObjId:    &objId,
+		}
+		gemOut, err = trace.NewGem5Writer(gemOutFile, &header)
+		if err != nil {
+			log.Fatal("Unable to setup gem output: ", err)
+		}
+		log.Println("Setup gem output")
+	}
+	selected := trace.Select(in, opts)
+	for {
+		a, err := selected.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("err:", err)
+			}
+			break
+		}
+		addr, tick := a.Addr, a.Tick
+		if !stats.processAccess(a) {
+			continue
+		}
+
+		if gemOut != nil {
+			cmd := opts.Commands.Encode(a.Cmd)
+			size := a.Size
+			if size == 0 {
+				size = 8
+			}
+			packet := pb.Packet{
+				Tick: &tick,
+				Addr: &addr,
+				Cmd:  &cmd,
+				Size: &size,
+			}
+
+			if err := gemOut.WritePacket(&packet); err != nil {
+				log.Fatal("Unable to write packet: ", err)
+			}
+		}
+	}
+	if gemOut != nil {
+		gemOut.Flush()
+	}
+	stats.flush()
+	stats.print()
+
+}
+
+func reportSkipped(path string, in *trace.Gem5Reader) {
+	if in.Skipped > 0 {
+		log.Printf("Skipped %d damaged packets (%d bytes) in %s\n", in.Skipped, in.SkippedBytes, path)
+	}
+}
+
+// flagSet reports whether the flag with the given name was given on the
+// command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
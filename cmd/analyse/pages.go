@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// pageRecord holds the counters of one block, the counters saturate instead
+// of wrapping around
+type pageRecord struct {
+	reads, writes, fetches uint32
+	window                 uint32 // Last window the block was accessed in
+}
+
+func increment(c *uint32) {
+	if *c != math.MaxUint32 {
+		*c++
+	}
+}
+
+// pageTotals is the amount of distinct blocks accessed in each way
+type pageTotals struct {
+	accessed, read, written, fetched uint64
+}
+
+// approximatePages estimates the footprint in a fixed amount of memory
+type approximatePages struct {
+	sketch                           countMinSketch
+	accessed, read, written, fetched hyperLogLog
+	window                           hyperLogLog
+	hot                              map[uint64]uint64 // Candidates for the most accessed blocks
+	hot_min                          uint64            // Lower bound of the accesses of the candidates
+}
+
+// Amount of blocks that are tracked as candidates for the most accessed
+// blocks in approximate mode
+const hotCandidates = 64
+
+func (a *approximatePages) add(block uint64, kind trace.Kind) {
+	a.sketch.add(block, kind)
+	a.accessed.add(block)
+	a.window.add(block)
+	if kind.IsWrite() {
+		a.written.add(block)
+	} else if kind == trace.Fetch {
+		a.fetched.add(block)
+	} else {
+		a.read.add(block)
+	}
+
+	counts := a.sketch.count(block)
+	count := counts.total()
+	if _, ok := a.hot[block]; ok || len(a.hot) < hotCandidates {
+		a.hot[block] = count
+		return
+	}
+	if count <= a.hot_min {
+		return
+	}
+	coldest, min := uint64(0), uint64(math.MaxUint64)
+	for b, c := range a.hot {
+		if c < min {
+			coldest, min = b, c
+		}
+	}
+	if count > min {
+		delete(a.hot, coldest)
+		a.hot[block] = count
+	}
+	a.hot_min = min
+}
+
+// footprint tracks the blocks of one granularity that are accessed. An exact
+// footprint keeps one record per block, an approximate one estimates the
+// counts with a count-min sketch and the amount of blocks with HyperLogLog.
+type footprint struct {
+	granularity
+	pages                map[uint64]pageRecord // Nil if approximate
+	approx               *approximatePages
+	totals               pageTotals
+	window               uint32 // Starts at one so new records are outside of it
+	window_pages         uint64
+	min_block, max_block uint64
+}
+
+func newFootprint(g granularity, approximate bool) *footprint {
+	f := &footprint{granularity: g, window: 1, min_block: math.MaxUint64}
+	if approximate {
+		f.approx = &approximatePages{hot: map[uint64]uint64{}}
+	} else {
+		f.pages = map[uint64]pageRecord{}
+	}
+	return f
+}
+
+func (f *footprint) add(addr uint64, kind trace.Kind) {
+	block := f.block(addr)
+	if block < f.min_block {
+		f.min_block = block
+	}
+	if block > f.max_block {
+		f.max_block = block
+	}
+	if f.approx != nil {
+		f.approx.add(block, kind)
+		return
+	}
+	r, ok := f.pages[block]
+	if !ok {
+		f.totals.accessed++
+	}
+	if r.window != f.window {
+		r.window = f.window
+		f.window_pages++
+	}
+	if kind.IsWrite() {
+		if r.writes == 0 {
+			f.totals.written++
+		}
+		increment(&r.writes)
+	} else if kind == trace.Fetch {
+		if r.fetches == 0 {
+			f.totals.fetched++
+		}
+		increment(&r.fetches)
+	} else {
+		if r.reads == 0 {
+			f.totals.read++
+		}
+		increment(&r.reads)
+	}
+	f.pages[block] = r
+}
+
+// pageTotals returns the amount of distinct blocks accessed
+func (f *footprint) pageTotals() pageTotals {
+	if f.approx == nil {
+		return f.totals
+	}
+	return pageTotals{
+		accessed: f.approx.accessed.estimate(),
+		read:     f.approx.read.estimate(),
+		written:  f.approx.written.estimate(),
+		fetched:  f.approx.fetched.estimate(),
+	}
+}
+
+// windowPages returns the amount of distinct blocks accessed in the current
+// window
+func (f *footprint) windowPages() uint64 {
+	if f.approx == nil {
+		return f.window_pages
+	}
+	return f.approx.window.estimate()
+}
+
+func (f *footprint) newWindow() {
+	f.window++
+	f.window_pages = 0
+	if f.approx != nil {
+		f.approx.window.reset()
+	}
+}
+
+// hottest returns at most n of the most accessed blocks, ordered by the
+// amount of accesses
+func (f *footprint) hottest(n int) []pageCounts {
+	var pages []pageCounts
+	if f.approx != nil {
+		for block := range f.approx.hot {
+			pages = append(pages, pageCounts{page: block, accessCounts: f.approx.sketch.count(block)})
+		}
+	} else {
+		for block, r := range f.pages {
+			pages = append(pages, pageCounts{page: block, accessCounts: accessCounts{
+				total_reads:  uint64(r.reads),
+				total_writes: uint64(r.writes),
+				total_fetch:  uint64(r.fetches),
+			}})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].total() != pages[j].total() {
+			return pages[i].total() > pages[j].total()
+		}
+		return pages[i].page < pages[j].page
+	})
+	if len(pages) > n {
+		pages = pages[:n]
+	}
+	return pages
+}
+
+func (f *footprint) csvHeader() []string {
+	suffix := f.columnSuffix()
+	return []string{"total_pages_accessed" + suffix, "total_pages_written" + suffix, "total_pages_read" + suffix, "total_pages_fetched" + suffix}
+}
+
+func (f *footprint) csvRecord() []string {
+	totals := f.pageTotals()
+	return []string{
+		strconv.FormatUint(totals.accessed, 10),
+		strconv.FormatUint(totals.written, 10),
+		strconv.FormatUint(totals.read, 10),
+		strconv.FormatUint(totals.fetched, 10),
+	}
+}
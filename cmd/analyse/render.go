@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Size of the plot area the heatmap cells are scaled to
+const (
+	plotWidth  = 800
+	plotHeight = 400
+)
+
+// Font used for the labels of PNG images, every glyph is 3x5 pixels with
+// the rows stored in the lowest three bits
+var glyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7}, '4': {5, 5, 7, 1, 1}, '5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7}, '7': {7, 1, 1, 1, 1}, '8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7}, 'a': {0, 3, 5, 5, 3}, 'b': {4, 6, 5, 5, 6},
+	'c': {0, 3, 4, 4, 3}, 'd': {1, 3, 5, 5, 3}, 'e': {0, 2, 5, 6, 3},
+	'f': {1, 2, 7, 2, 2}, 'h': {4, 4, 6, 5, 5}, 'i': {2, 0, 2, 2, 2},
+	'm': {0, 7, 7, 5, 5}, 'n': {0, 6, 5, 5, 5}, 'r': {0, 5, 6, 4, 4},
+	's': {0, 3, 6, 3, 6}, 't': {2, 7, 2, 2, 1}, 'u': {0, 5, 5, 5, 3},
+	'w': {0, 5, 5, 7, 7}, 'x': {0, 0, 5, 2, 5}, '.': {0, 0, 0, 0, 2},
+	'-': {0, 0, 7, 0, 0},
+}
+
+const (
+	fontScale   = 2
+	glyphWidth  = 4 * fontScale // Including spacing
+	glyphHeight = 5 * fontScale
+)
+
+var (
+	writeColor = color.RGBA{R: 255, A: 255}
+	readColor  = color.RGBA{G: 255, A: 255}
+	fetchColor = color.RGBA{B: 255, A: 255}
+	labelColor = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+// heatmapScale maps the counts of a grid to colours, every kind of access
+// has its own channel which is scaled logarithmically to its maximum
+type heatmapScale struct {
+	max_reads, max_writes, max_fetch uint64
+}
+
+func newHeatmapScale(grid *heatmapGrid) heatmapScale {
+	var scale heatmapScale
+	for _, counts := range grid.counts {
+		for _, c := range counts {
+			if c.total_reads > scale.max_reads {
+				scale.max_reads = c.total_reads
+			}
+			if c.total_writes > scale.max_writes {
+				scale.max_writes = c.total_writes
+			}
+			if c.total_fetch > scale.max_fetch {
+				scale.max_fetch = c.total_fetch
+			}
+		}
+	}
+	return scale
+}
+
+func logScale(v, max uint64) uint8 {
+	if v == 0 {
+		return 0
+	}
+	return uint8(math.Round(255 * math.Log1p(float64(v)) / math.Log1p(float64(max))))
+}
+
+func (s heatmapScale) color(c accessCounts) color.RGBA {
+	return color.RGBA{
+		R: logScale(c.total_writes, s.max_writes),
+		G: logScale(c.total_reads, s.max_reads),
+		B: logScale(c.total_fetch, s.max_fetch),
+		A: 255,
+	}
+}
+
+// heatmapLayout positions the cells and labels of a heatmap image
+type heatmapLayout struct {
+	grid                      *heatmapGrid
+	cell_width, cell_height   int
+	left, top, width, height  int // Plot area
+	image_width, image_height int
+	start_label, end_label    string // Address range
+	time_label                string // End of the last window
+}
+
+func newHeatmapLayout(grid *heatmapGrid) *heatmapLayout {
+	l := &heatmapLayout{grid: grid, cell_width: 1, cell_height: 1}
+	windows, buckets := len(grid.counts), 0
+	if windows > 0 {
+		buckets = len(grid.counts[0])
+		l.time_label = formatTime(grid.times[windows-1])
+	}
+	if windows > 0 && windows < plotWidth {
+		l.cell_width = plotWidth / windows
+	}
+	if buckets > 0 && buckets < plotHeight {
+		l.cell_height = plotHeight / buckets
+	}
+	l.start_label = "0x" + strconv.FormatUint(grid.start, 16)
+	l.end_label = "0x" + strconv.FormatUint(grid.bucketAddr(buckets), 16)
+	labelWidth := len(l.start_label)
+	if len(l.end_label) > labelWidth {
+		labelWidth = len(l.end_label)
+	}
+	if labelWidth < 4 {
+		labelWidth = 4 // Axis name
+	}
+	l.left = (labelWidth + 1) * glyphWidth
+	l.top = 3 * glyphHeight // Room for the legend
+	l.width = windows * l.cell_width
+	l.height = buckets * l.cell_height
+	l.image_width = l.left + l.width + len(l.time_label)*glyphWidth/2 + glyphWidth
+	l.image_height = l.top + l.height + 4*glyphHeight
+	return l
+}
+
+// cell returns the top left corner of the cell of window and bucket, low
+// addresses are at the bottom
+func (l *heatmapLayout) cell(window, bucket int) (int, int) {
+	return l.left + window*l.cell_width, l.top + l.height - (bucket+1)*l.cell_height
+}
+
+// formatTime formats a duration in nanoseconds for an axis label
+func formatTime(ns uint64) string {
+	units := []string{"ns", "us", "ms", "s"}
+	v := float64(ns)
+	unit := 0
+	for math.Round(v*1000)/1000 >= 1000 && unit < len(units)-1 {
+		v /= 1000
+		unit++
+	}
+	// The font has no exponent sign, so avoid the exponent format
+	s := strings.TrimRight(strconv.FormatFloat(v, 'f', 3, 64), "0")
+	return strings.TrimSuffix(s, ".") + units[unit]
+}
+
+// renderHeatmap draws grid with writes in the red, reads in the green and
+// fetches in the blue channel
+func renderHeatmap(grid *heatmapGrid) *image.RGBA {
+	l := newHeatmapLayout(grid)
+	scale := newHeatmapScale(grid)
+	img := image.NewRGBA(image.Rect(0, 0, l.image_width, l.image_height))
+	fill(img, img.Bounds(), color.RGBA{A: 255})
+	for window, counts := range grid.counts {
+		for bucket, c := range counts {
+			x, y := l.cell(window, bucket)
+			fill(img, image.Rect(x, y, x+l.cell_width, y+l.cell_height), scale.color(c))
+		}
+	}
+	// Axes
+	fill(img, image.Rect(l.left-1, l.top, l.left, l.top+l.height+1), labelColor)
+	fill(img, image.Rect(l.left-1, l.top+l.height, l.left+l.width, l.top+l.height+1), labelColor)
+
+	x := l.left
+	for _, entry := range []struct {
+		text  string
+		color color.RGBA
+	}{{"writes", writeColor}, {"reads", readColor}, {"fetches", fetchColor}} {
+		drawText(img, x, glyphHeight, entry.text, entry.color)
+		x += (len(entry.text) + 2) * glyphWidth
+	}
+	drawText(img, l.left-(len(l.end_label)+1)*glyphWidth, l.top, l.end_label, labelColor)
+	drawText(img, l.left-(len(l.start_label)+1)*glyphWidth, l.top+l.height-glyphHeight, l.start_label, labelColor)
+	drawText(img, 0, l.top+l.height/2-glyphHeight/2, "addr", labelColor)
+	labelY := l.top + l.height + glyphHeight
+	drawText(img, l.left, labelY, "0ns", labelColor)
+	drawText(img, l.left+l.width-len(l.time_label)*glyphWidth/2, labelY, l.time_label, labelColor)
+	drawText(img, l.left+l.width/2-2*glyphWidth, labelY+2*glyphHeight, "time", labelColor)
+	return img
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawText draws text with its top left corner at x, y, characters without
+// a glyph are left blank
+func drawText(img *image.RGBA, x, y int, text string, c color.RGBA) {
+	for i, char := range text {
+		glyph := glyphs[char]
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>uint(col)) != 0 {
+					px, py := x+i*glyphWidth+col*fontScale, y+row*fontScale
+					fill(img, image.Rect(px, py, px+fontScale, py+fontScale), c)
+				}
+			}
+		}
+	}
+}
+
+// writeHeatmapSVG writes grid as SVG image with the same layout as the PNG
+// output
+func writeHeatmapSVG(w io.Writer, grid *heatmapGrid) error {
+	l := newHeatmapLayout(grid)
+	scale := newHeatmapScale(grid)
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"monospace\" font-size=\"%d\">\n", l.image_width, l.image_height, glyphHeight+2)
+	fmt.Fprintf(out, "<rect width=\"%d\" height=\"%d\" fill=\"black\"/>\n", l.image_width, l.image_height)
+	for window, counts := range grid.counts {
+		for bucket, c := range counts {
+			col := scale.color(c)
+			if col.R == 0 && col.G == 0 && col.B == 0 {
+				continue
+			}
+			x, y := l.cell(window, bucket)
+			fmt.Fprintf(out, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\"><title>%s %s: %d reads, %d writes, %d fetches</title></rect>\n",
+				x, y, l.cell_width, l.cell_height, col.R, col.G, col.B,
+				formatTime(grid.times[window]), "0x"+strconv.FormatUint(grid.bucketAddr(bucket), 16), c.total_reads, c.total_writes, c.total_fetch)
+		}
+	}
+	fmt.Fprintf(out, "<path d=\"M%d %dV%dH%d\" stroke=\"white\" fill=\"none\"/>\n", l.left, l.top, l.top+l.height, l.left+l.width)
+	fmt.Fprintf(out, "<g fill=\"white\">\n")
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" fill=\"red\">writes</text>\n", l.left, 2*glyphHeight)
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" fill=\"lime\">reads</text>\n", l.left+8*glyphWidth, 2*glyphHeight)
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" fill=\"blue\">fetches</text>\n", l.left+15*glyphWidth, 2*glyphHeight)
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%s</text>\n", l.left-glyphWidth, l.top+glyphHeight, l.end_label)
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%s</text>\n", l.left-glyphWidth, l.top+l.height, l.start_label)
+	fmt.Fprintf(out, "<text x=\"0\" y=\"%d\">addr</text>\n", l.top+l.height/2)
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\">0ns</text>\n", l.left, l.top+l.height+2*glyphHeight)
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\">%s</text>\n", l.left+l.width, l.top+l.height+2*glyphHeight, l.time_label)
+	fmt.Fprintf(out, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\">time</text>\n", l.left+l.width/2, l.top+l.height+4*glyphHeight)
+	fmt.Fprintf(out, "</g>\n</svg>\n")
+	return out.Flush()
+}
+
+// checkImageFormat returns an error if the extension of path is not a
+// supported image format
+func checkImageFormat(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".png" && ext != ".svg" {
+		return fmt.Errorf("Unknown image format: %q, expected .png or .svg", ext)
+	}
+	return nil
+}
+
+// writeHeatmapImage renders the heatmap to path, the format is chosen by the
+// extension of path
+func (s *Stats) writeHeatmapImage(path string, buckets int, reset bool) error {
+	if err := checkImageFormat(path); err != nil {
+		return err
+	}
+	// Every window needs at least one pixel
+	grid := s.heatmapGrid(buckets, reset).mergeWindows(plotWidth)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".svg" {
+		err = writeHeatmapSVG(f, grid)
+	} else {
+		err = png.Encode(f, renderHeatmap(grid))
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
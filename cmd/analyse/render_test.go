@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		ns    uint64
+		label string
+	}{
+		{0, "0ns"},
+		{999, "999ns"},
+		{1500, "1.5us"},
+		{999999, "999.999us"},
+		{9999999, "10ms"},
+		{20000000000, "20s"},
+		{12345678900000, "12345.679s"},
+	}
+	for _, test := range tests {
+		if label := formatTime(test.ns); label != test.label {
+			t.Errorf("%dns formatted as %q, expected %q", test.ns, label, test.label)
+		}
+		for _, char := range formatTime(test.ns) {
+			if _, ok := glyphs[char]; !ok {
+				t.Errorf("%dns formatted with %q, which has no glyph", test.ns, char)
+			}
+		}
+	}
+}
+
+// TestMergeWindows checks that a heatmap with more windows than the plot is
+// wide keeps its width, with the counts of the merged windows combined
+func TestMergeWindows(t *testing.T) {
+	const windows = 2*plotWidth + 1
+	for _, reset := range []bool{false, true} {
+		h := newHeatmap(pageGranularity, 0)
+		for w := 0; w < windows; w++ {
+			h.add(0x1000, 0)
+			h.closeWindow(uint64(w + 1))
+		}
+		grid := h.grid(0x1000, 0x1000, 1, reset).mergeWindows(plotWidth)
+		if len(grid.counts) != plotWidth {
+			t.Fatalf("Reset %v: merged into %d windows, expected %d", reset, len(grid.counts), plotWidth)
+		}
+		if l := newHeatmapLayout(grid); l.width != plotWidth {
+			t.Errorf("Reset %v: plot of %d pixels, expected %d", reset, l.width, plotWidth)
+		}
+		// The windows are split into groups of two and three
+		last := len(grid.counts) - 1
+		if grid.times[0] != 2 || grid.times[last] != windows {
+			t.Errorf("Reset %v: merged windows end at %d and %d, expected 2 and %d", reset, grid.times[0], grid.times[last], windows)
+		}
+		expected := []uint64{2, windows}
+		if reset {
+			expected = []uint64{2, 3}
+		}
+		if first, end := grid.counts[0][0].total(), grid.counts[last][0].total(); first != expected[0] || end != expected[1] {
+			t.Errorf("Reset %v: %d and %d accesses in the first and last window, expected %v", reset, first, end, expected)
+		}
+	}
+}
+
+// TestHeatmapLimit checks that a heatmap exceeding its limit merges
+// neighbouring pages and keeps the total counts
+func TestHeatmapLimit(t *testing.T) {
+	h := newHeatmap(pageGranularity, 64)
+	for w := 0; w < 4; w++ {
+		for page := uint64(0); page < 32; page++ {
+			h.add(page<<12, 0)
+		}
+		h.closeWindow(uint64(w + 1))
+	}
+	if h.stored > 64 || h.shift != 13 {
+		t.Errorf("%d page counts of %s, expected at most 64 of 8KiB", h.stored, h.granularity)
+	}
+	grid := h.grid(0, 31<<12, 256, true)
+	if len(grid.counts) != 4 || len(grid.counts[0]) != 16 || grid.bucket_size != 8192 {
+		t.Fatalf("Grid of %d windows, %d buckets of %d bytes, expected 4 windows and 16 buckets of 8KiB", len(grid.counts), len(grid.counts[0]), grid.bucket_size)
+	}
+	for w, counts := range grid.counts {
+		for bucket, c := range counts {
+			if c.total() != 2 {
+				t.Errorf("Window %d bucket %d: %d accesses, expected 2", w, bucket, c.total())
+			}
+		}
+	}
+}
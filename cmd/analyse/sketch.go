@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/doriandekoning/memory-trace-analyser/reuse"
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// hllPrecision is the amount of hash bits used to select a register, the
+// standard error of the estimate is 1.04/sqrt(2^hllPrecision), about 0.8%
+const hllPrecision = 14
+
+// hyperLogLog estimates the amount of distinct values added to it in a fixed
+// amount of memory
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+func (h *hyperLogLog) add(value uint64) {
+	x := reuse.Hash64(value)
+	idx := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) reset() {
+	h.registers = [1 << hllPrecision]uint8{}
+}
+
+// estimate returns the estimated amount of distinct values
+func (h *hyperLogLog) estimate() uint64 {
+	const m = float64(1 << hllPrecision)
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// Dimensions of the count-min sketch, the counts are overestimated by at most
+// e/cmsWidth times the total amount of accesses with probability
+// 1-exp(-cmsDepth)
+const (
+	cmsDepth = 4
+	cmsWidth = 1 << 16
+)
+
+// countMinSketch estimates the accesses per block in a fixed amount of memory,
+// the estimates are never lower than the actual counts
+type countMinSketch struct {
+	rows [cmsDepth][cmsWidth]accessCounts
+}
+
+func (c *countMinSketch) cell(row int, block uint64) *accessCounts {
+	return &c.rows[row][reuse.Hash64(block^uint64(row)*0x9e3779b97f4a7c15)%cmsWidth]
+}
+
+func (c *countMinSketch) add(block uint64, kind trace.Kind) {
+	for row := range c.rows {
+		c.cell(row, block).add(kind)
+	}
+}
+
+func (c *countMinSketch) count(block uint64) accessCounts {
+	est := *c.cell(0, block)
+	for row := 1; row < cmsDepth; row++ {
+		cell := c.cell(row, block)
+		if cell.total_reads < est.total_reads {
+			est.total_reads = cell.total_reads
+		}
+		if cell.total_writes < est.total_writes {
+			est.total_writes = cell.total_writes
+		}
+		if cell.total_fetch < est.total_fetch {
+			est.total_fetch = cell.total_fetch
+		}
+	}
+	return est
+}
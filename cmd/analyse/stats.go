@@ -0,0 +1,682 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/doriandekoning/memory-trace-analyser/reuse"
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+type Stats struct {
+	outside_region  uint64
+	started         bool
+	start_timestamp uint64
+	last_timestamp  uint64 // Relative to start_timestamp
+	window_size     uint64 // Accesses per window, 0 disables access windows
+	window_interval uint64 // Nanoseconds per window, 0 disables time windows
+	window_end      uint64 // End of the current time window
+	window          accessCounts
+	total_writes    uint64
+	total_reads     uint64
+	total_fetch     uint64
+	min_addr        uint64
+	max_addr        uint64
+	footprints      []*footprint // The first granularity is used for the per CPU and region pages
+	cmd_counts      [trace.NumCommands]uint64
+	memmap          *trace.MemoryMap
+	regions         []*regionStats // Indexed by memory map region
+	region_order    []*regionStats
+	cpus            []*cpuStats
+	size_counts     map[uint32]*accessCounts
+	pcs             map[uint64]*pcStats // Nil unless the per program counter output is written
+	total_misses    uint64
+	csvWriter       *csv.Writer
+	cpuCsvWriter    *csv.Writer // Optional per-CPU output
+	heatmap         *heatmap    // Optional time x address heatmap
+	reuse           []*reuse.Analyser
+	caches          []*simulatedCache
+	hierarchy       *simulatedHierarchy // Optional multi-level cache hierarchy
+	cacheCsvWriter  *csv.Writer         // Optional per window cache output
+	validation      *validation         // Optional comparison of the hierarchy with the miss stream
+	// Optional instruction count, adds the per kilo instruction columns
+	instructions              *trace.InstructionCounter
+	window_instructions       uint64 // Instructions per window, 0 disables instruction windows
+	window_start_instructions uint64 // Instruction count at the start of the window
+	window_misses             uint64 // Accesses of the miss streams in the window
+	window_memory_requests    uint64 // Simulated memory reads and writes at the start of the window
+	// Estimate the pages in a fixed amount of memory
+	approximate bool
+}
+
+type accessCounts struct {
+	total_reads  uint64
+	total_writes uint64
+	total_fetch  uint64
+}
+
+func (c *accessCounts) add(kind trace.Kind) {
+	if kind.IsWrite() {
+		c.total_writes++
+	} else if kind == trace.Fetch {
+		c.total_fetch++
+	} else {
+		c.total_reads++
+	}
+}
+
+func (c *accessCounts) total() uint64 {
+	return c.total_reads + c.total_writes + c.total_fetch
+}
+
+// Flags stored per page to track the footprint of a CPU
+const (
+	pageRead uint8 = 1 << iota
+	pageWritten
+	pageFetched
+)
+
+type cpuStats struct {
+	accessCounts
+	name          string
+	requestors    map[uint64]bool  // Requestor ids already added to the name
+	pages         map[uint64]uint8 // Nil if approximate
+	pages_read    int
+	pages_written int
+	pages_fetched int
+	approx        *cpuPages
+}
+
+// cpuPages estimates the pages of a CPU in approximate mode
+type cpuPages struct {
+	accessed, read, written, fetched hyperLogLog
+}
+
+func (c *cpuStats) add(page uint64, kind trace.Kind) {
+	c.accessCounts.add(kind)
+	if c.approx != nil {
+		c.approx.accessed.add(page)
+		if kind.IsWrite() {
+			c.approx.written.add(page)
+		} else if kind == trace.Fetch {
+			c.approx.fetched.add(page)
+		} else {
+			c.approx.read.add(page)
+		}
+		return
+	}
+	flag := pageRead
+	if kind.IsWrite() {
+		flag = pageWritten
+	} else if kind == trace.Fetch {
+		flag = pageFetched
+	}
+	flags := c.pages[page]
+	if flags&flag != 0 {
+		return
+	}
+	c.pages[page] = flags | flag
+	switch flag {
+	case pageRead:
+		c.pages_read++
+	case pageWritten:
+		c.pages_written++
+	case pageFetched:
+		c.pages_fetched++
+	}
+}
+
+// pageTotals returns the amount of distinct pages accessed by the CPU
+func (c *cpuStats) pageTotals() pageTotals {
+	if c.approx == nil {
+		return pageTotals{
+			accessed: uint64(len(c.pages)),
+			read:     uint64(c.pages_read),
+			written:  uint64(c.pages_written),
+			fetched:  uint64(c.pages_fetched),
+		}
+	}
+	return pageTotals{
+		accessed: c.approx.accessed.estimate(),
+		read:     c.approx.read.estimate(),
+		written:  c.approx.written.estimate(),
+		fetched:  c.approx.fetched.estimate(),
+	}
+}
+
+type pcStats struct {
+	accessCounts
+	misses uint64
+}
+
+type regionStats struct {
+	name string
+	accessCounts
+	pages *hyperLogLog // Distinct pages in approximate mode
+}
+
+func newStats(csvWriter *csv.Writer, memmap *trace.MemoryMap, grans []granularity, approximate bool) *Stats {
+	if len(grans) == 0 {
+		grans = []granularity{pageGranularity}
+	}
+	s := &Stats{
+		size_counts: map[uint32]*accessCounts{},
+		memmap:      memmap,
+		regions:     make([]*regionStats, len(memmap.Regions)),
+		csvWriter:   csvWriter,
+		approximate: approximate,
+	}
+	// Regions split up by higher priority regions share their stats
+	byName := map[string]*regionStats{}
+	for i, region := range memmap.Regions {
+		r, ok := byName[region.Name]
+		if !ok {
+			r = &regionStats{name: region.Name}
+			if approximate {
+				r.pages = &hyperLogLog{}
+			}
+			byName[region.Name] = r
+			s.region_order = append(s.region_order, r)
+		}
+		s.regions[i] = r
+	}
+	for _, g := range grans {
+		s.footprints = append(s.footprints, newFootprint(g, approximate))
+	}
+	return s
+}
+
+// pages returns the footprint at the granularity used for the breakdowns
+func (s *Stats) pages() *footprint {
+	return s.footprints[0]
+}
+
+func (s *Stats) cpu(cpu int) *cpuStats {
+	for len(s.cpus) <= cpu {
+		c := &cpuStats{}
+		if s.approximate {
+			c.approx = &cpuPages{}
+		} else {
+			c.pages = map[uint64]uint8{}
+		}
+		s.cpus = append(s.cpus, c)
+	}
+	return s.cpus[cpu]
+}
+
+// labelCPU adds the name of a traced object, e.g. a cache, to the name of
+// the cpu
+func (s *Stats) labelCPU(cpu int, name string) {
+	c := s.cpu(cpu)
+	if name == "" || strings.Contains("+"+c.name+"+", "+"+name+"+") {
+		return
+	}
+	if c.name != "" {
+		c.name += "+"
+	}
+	c.name += name
+}
+
+// labelRequestor adds the name of the gem5 requestor id, e.g.
+// system.cpu0.dcache, to the name of the cpu
+func (s *Stats) labelRequestor(cpu int, id uint64, name string) {
+	c := s.cpu(cpu)
+	if c.requestors[id] {
+		return
+	}
+	if c.requestors == nil {
+		c.requestors = map[uint64]bool{}
+	}
+	c.requestors[id] = true
+	s.labelCPU(cpu, name)
+}
+
+// processAccess adds a to the statistics, it returns false if the access
+// lies outside of the memory map and was discarded
+func (s *Stats) processAccess(a trace.Access) bool {
+	s.cmd_counts[a.Cmd]++
+	if !a.Kind.IsRead() && !a.Kind.IsWrite() && a.Kind != trace.Fetch {
+		return true
+	}
+	if s.instructions != nil && !a.Miss {
+		s.instructions.Add(a)
+	}
+	addr, timestamp := a.Addr, a.Time
+	regionIdx := s.memmap.Lookup(addr)
+	if regionIdx < 0 {
+		s.outside_region++
+		return false
+	}
+	var pc *pcStats
+	if a.PC != 0 && s.pcs != nil {
+		pc = s.pcs[a.PC]
+		if pc == nil {
+			pc = &pcStats{}
+			s.pcs[a.PC] = pc
+		}
+	}
+	if a.Miss {
+		// Misses duplicate accesses of the CPU streams, only attribute them
+		s.total_misses++
+		s.window_misses++
+		if pc != nil {
+			pc.misses++
+		}
+		if s.validation != nil {
+			s.validation.addRecorded(a)
+		}
+		return true
+	}
+	if pc != nil {
+		pc.add(a.Kind)
+	}
+	s.regions[regionIdx].add(a.Kind)
+	if s.regions[regionIdx].pages != nil {
+		s.regions[regionIdx].pages.add(s.pages().block(addr))
+	}
+	s.cpu(a.CPU).add(s.pages().block(addr), a.Kind)
+	sizeCounts, ok := s.size_counts[a.Size]
+	if !ok {
+		sizeCounts = &accessCounts{}
+		s.size_counts[a.Size] = sizeCounts
+	}
+	sizeCounts.add(a.Kind)
+
+	if !s.started {
+		s.started = true
+		s.start_timestamp = timestamp
+		s.window_end = s.window_interval
+	}
+	s.last_timestamp = timestamp - s.start_timestamp
+	for s.window_interval > 0 && s.last_timestamp >= s.window_end {
+		// The access belongs to a later window, close the elapsed ones
+		s.writeOut(s.window_end)
+		s.window_end += s.window_interval
+	}
+	s.window.add(a.Kind)
+	for _, f := range s.footprints {
+		f.add(addr, a.Kind)
+	}
+	if s.heatmap != nil {
+		s.heatmap.add(addr, a.Kind)
+	}
+	for _, r := range s.reuse {
+		r.Add(addr)
+	}
+	for _, c := range s.caches {
+		c.add(a)
+		if len(c.pending) > 0 {
+			s.writeOutPending(c)
+		}
+	}
+	if s.hierarchy != nil {
+		s.hierarchy.add(a)
+	}
+	if a.Kind.IsWrite() {
+		s.total_writes++
+	} else {
+		if a.Kind == trace.Fetch {
+			s.total_fetch++
+		} else {
+			s.total_reads++
+		}
+	}
+	if s.window_size > 0 && s.window.total() >= s.window_size {
+		s.writeOut(s.last_timestamp)
+	} else if s.window_instructions > 0 && s.instructions.Count()-s.window_start_instructions >= s.window_instructions {
+		s.writeOut(s.last_timestamp)
+	}
+	total := s.total_writes + s.total_reads + s.total_fetch
+	if total%10000000 == 0 {
+		log.Printf("Processed: %d million accesses\n", total/1000000)
+		log.Println("Total pages accessed: ", s.pages().pageTotals().accessed)
+		s.print()
+	}
+	return true
+}
+
+// flush closes the current window and flushes the CSV outputs
+func (s *Stats) flush() {
+	if s.window.total() > 0 {
+		s.writeOut(s.last_timestamp)
+	}
+	s.flushCaches()
+	s.csvWriter.Flush()
+	if s.cpuCsvWriter != nil {
+		s.cpuCsvWriter.Flush()
+	}
+	if s.cacheCsvWriter != nil {
+		s.cacheCsvWriter.Flush()
+	}
+	if s.validation != nil && s.validation.csvWriter != nil {
+		s.validation.csvWriter.Flush()
+	}
+}
+
+// csvHeader returns the header of the main CSV output, every granularity adds
+// its own set of page columns
+func (s *Stats) csvHeader() []string {
+	header := []string{"timestamp", "total_accesses", "total_reads", "total_writes"}
+	for _, f := range s.footprints {
+		header = append(header, f.csvHeader()...)
+	}
+	header = append(header, "window_accesses", "window_reads", "window_writes", "window_fetch")
+	for _, f := range s.footprints {
+		header = append(header, "window_pages_accessed"+f.columnSuffix())
+	}
+	if s.instructions != nil {
+		header = append(header, s.instructionCSVHeader()...)
+	}
+	return header
+}
+
+// instructionCSVHeader returns the per kilo instruction columns of the main
+// CSV output
+func (s *Stats) instructionCSVHeader() []string {
+	header := []string{"instructions", "window_instructions", "window_misses", "mpki", "reads_per_ki", "writes_per_ki"}
+	if s.hierarchy != nil {
+		header = append(header, "simulated_mpki")
+	}
+	return header
+}
+
+// instructionCSVRecord returns the per kilo instruction columns of the window
+// and starts a new one
+func (s *Stats) instructionCSVRecord() []string {
+	instructions := s.instructions.Count() - s.window_start_instructions
+	record := []string{
+		strconv.FormatUint(s.instructions.Count(), 10),
+		strconv.FormatUint(instructions, 10),
+		strconv.FormatUint(s.window_misses, 10),
+		strconv.FormatFloat(trace.PerKilo(s.window_misses, instructions), 'f', 6, 64),
+		strconv.FormatFloat(trace.PerKilo(s.window.total_reads, instructions), 'f', 6, 64),
+		strconv.FormatFloat(trace.PerKilo(s.window.total_writes, instructions), 'f', 6, 64),
+	}
+	if s.hierarchy != nil {
+		// Counted like the miss stream, which holds the reads and writebacks
+		requests := s.hierarchy.MemoryReads + s.hierarchy.MemoryWrites
+		record = append(record, strconv.FormatFloat(trace.PerKilo(requests-s.window_memory_requests, instructions), 'f', 6, 64))
+		s.window_memory_requests = requests
+	}
+	s.window_start_instructions = s.instructions.Count()
+	s.window_misses = 0
+	return record
+}
+
+func (s *Stats) cpuCSVHeader() []string {
+	header := []string{"timestamp", "cpu", "total_accesses", "total_reads", "total_writes", "total_fetch"}
+	header = append(header, s.pages().csvHeader()...)
+	return append(header, "cpu_name")
+}
+
+func (s *Stats) writeOutCPUs(timestamp uint64) {
+	for cpu, c := range s.cpus {
+		pages := c.pageTotals()
+		s.cpuCsvWriter.Write([]string{
+			strconv.FormatUint(timestamp, 10),
+			strconv.Itoa(cpu),
+			strconv.FormatUint(c.total(), 10),
+			strconv.FormatUint(c.total_reads, 10),
+			strconv.FormatUint(c.total_writes, 10),
+			strconv.FormatUint(c.total_fetch, 10),
+			strconv.FormatUint(pages.accessed, 10),
+			strconv.FormatUint(pages.written, 10),
+			strconv.FormatUint(pages.read, 10),
+			strconv.FormatUint(pages.fetched, 10),
+			c.name,
+		})
+	}
+}
+
+// writeOut writes the cumulative and window counters at timestamp and starts
+// a new window
+func (s *Stats) writeOut(timestamp uint64) {
+	if s.cpuCsvWriter != nil {
+		s.writeOutCPUs(timestamp)
+	}
+	s.writeOutCaches(timestamp)
+	if s.validation != nil {
+		s.validation.writeOut(timestamp)
+	}
+	record := []string{
+		strconv.Itoa(int(timestamp)),                                      // Timestamp
+		strconv.Itoa(int(s.total_reads + s.total_writes + s.total_fetch)), // Total writes
+		strconv.Itoa(int(s.total_reads)),                                  // Total reads
+		strconv.Itoa(int(s.total_writes)),                                 //Total writes
+	}
+	for _, f := range s.footprints {
+		record = append(record, f.csvRecord()...) // Pages accessed, written, read and fetched
+	}
+	record = append(record,
+		strconv.FormatUint(s.window.total(), 10),
+		strconv.FormatUint(s.window.total_reads, 10),
+		strconv.FormatUint(s.window.total_writes, 10),
+		strconv.FormatUint(s.window.total_fetch, 10),
+	)
+	for _, f := range s.footprints {
+		record = append(record, strconv.FormatUint(f.windowPages(), 10)) // Working set of the window
+		f.newWindow()
+	}
+	if s.instructions != nil {
+		record = append(record, s.instructionCSVRecord()...)
+	}
+	s.csvWriter.Write(record)
+	s.window = accessCounts{}
+	if s.heatmap != nil {
+		s.heatmap.closeWindow(timestamp)
+	}
+}
+
+func (s *Stats) print() {
+	log.Printf("Total accessses:\t\t%d\n", s.total_reads+s.total_writes+s.total_fetch)
+	log.Printf("Total reads: 	\t%d\n", s.total_reads)
+	log.Printf("Total writes:	\t%d\n", s.total_writes)
+	log.Printf("Total fetch: \t\t%d\n", s.total_fetch)
+	log.Printf("Ratio:\t\t	 %f\n", float64(s.total_writes)/float64(s.total_reads))
+	for _, f := range s.footprints {
+		log.Printf("Pages amount (%s):\t%d\n", f.granularity, f.pageTotals().accessed)
+	}
+	log.Printf("Outside region:\t\t%d\n", s.outside_region)
+	if s.total_misses > 0 {
+		log.Printf("Total misses:\t\t%d\n", s.total_misses)
+	}
+	if s.instructions != nil {
+		instructions := s.instructions.Count()
+		log.Printf("Instructions (%s):\t%d\n", s.instructions.Source(), instructions)
+		log.Printf("MPKI:\t\t\t%f\n", trace.PerKilo(s.total_misses, instructions))
+		log.Printf("Reads per KI:\t\t%f\n", trace.PerKilo(s.total_reads, instructions))
+		log.Printf("Writes per KI:\t\t%f\n", trace.PerKilo(s.total_writes, instructions))
+	}
+	for cmd, count := range s.cmd_counts {
+		if count > 0 {
+			log.Printf("%s:\t\t%d\n", trace.Command(cmd), count)
+		}
+	}
+}
+
+// regionPages returns the amount of pages accessed in each region
+func (s *Stats) regionPages() map[*regionStats]uint64 {
+	pages := map[*regionStats]uint64{}
+	for _, r := range s.region_order {
+		if r.pages != nil {
+			pages[r] = r.pages.estimate()
+		}
+	}
+	for page := range s.pages().pages {
+		if idx := s.memmap.Lookup(s.pages().addr(page)); idx >= 0 {
+			pages[s.regions[idx]]++
+		}
+	}
+	return pages
+}
+
+func (s *Stats) printRegions() {
+	pages := s.regionPages()
+	for _, r := range s.region_order {
+		log.Printf("Region %s:\treads: %d\twrites: %d\tfetch: %d\tpages: %d\n", r.name, r.total_reads, r.total_writes, r.total_fetch, pages[r])
+	}
+}
+
+// sizes returns the access sizes seen in increasing order
+func (s *Stats) sizes() []uint32 {
+	sizes := make([]uint32, 0, len(s.size_counts))
+	for size := range s.size_counts {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes
+}
+
+func (s *Stats) printBreakdowns() {
+	for cpu, c := range s.cpus {
+		log.Printf("CPU %d %s:\treads: %d\twrites: %d\tfetch: %d\tpages: %d\n", cpu, c.name, c.total_reads, c.total_writes, c.total_fetch, c.pageTotals().accessed)
+	}
+	for _, size := range s.sizes() {
+		c := s.size_counts[size]
+		log.Printf("Size %d:\treads: %d\twrites: %d\tfetch: %d\n", size, c.total_reads, c.total_writes, c.total_fetch)
+	}
+	for _, p := range s.pages().hottest(10) {
+		log.Printf("Page 0x%x:\treads: %d\twrites: %d\tfetch: %d\n", s.pages().addr(p.page), p.total_reads, p.total_writes, p.total_fetch)
+	}
+}
+
+// writePCsCSV writes the accesses and misses per program counter, ordered by
+// the amount of accesses
+func (s *Stats) writePCsCSV(csvWriter *csv.Writer) error {
+	pcs := make([]uint64, 0, len(s.pcs))
+	for pc := range s.pcs {
+		pcs = append(pcs, pc)
+	}
+	sort.Slice(pcs, func(i, j int) bool {
+		a, b := s.pcs[pcs[i]], s.pcs[pcs[j]]
+		if a.total() != b.total() {
+			return a.total() > b.total()
+		}
+		return pcs[i] < pcs[j]
+	})
+	csvWriter.Write([]string{"pc", "total_accesses", "total_reads", "total_writes", "total_fetch", "total_misses"})
+	for _, pc := range pcs {
+		c := s.pcs[pc]
+		csvWriter.Write([]string{
+			"0x" + strconv.FormatUint(pc, 16),
+			strconv.FormatUint(c.total(), 10),
+			strconv.FormatUint(c.total_reads, 10),
+			strconv.FormatUint(c.total_writes, 10),
+			strconv.FormatUint(c.total_fetch, 10),
+			strconv.FormatUint(c.misses, 10),
+		})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func (s *Stats) writeRegionsCSV(csvWriter *csv.Writer) error {
+	pages := s.regionPages()
+	csvWriter.Write([]string{"region", "total_accesses", "total_reads", "total_writes", "total_fetch", "total_pages_accessed" + s.pages().columnSuffix()})
+	for _, r := range s.region_order {
+		csvWriter.Write([]string{
+			r.name,
+			strconv.FormatUint(r.total(), 10),
+			strconv.FormatUint(r.total_reads, 10),
+			strconv.FormatUint(r.total_writes, 10),
+			strconv.FormatUint(r.total_fetch, 10),
+			strconv.FormatUint(pages[r], 10),
+		})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// writeSizesCSV writes the accesses per access size in bytes
+func (s *Stats) writeSizesCSV(csvWriter *csv.Writer) error {
+	csvWriter.Write([]string{"size", "total_accesses", "total_reads", "total_writes", "total_fetch"})
+	for _, size := range s.sizes() {
+		c := s.size_counts[size]
+		csvWriter.Write([]string{
+			strconv.FormatUint(uint64(size), 10),
+			strconv.FormatUint(c.total(), 10),
+			strconv.FormatUint(c.total_reads, 10),
+			strconv.FormatUint(c.total_writes, 10),
+			strconv.FormatUint(c.total_fetch, 10),
+		})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func (s *Stats) calcMinMax() {
+	s.min_addr = math.MaxUint64
+	s.max_addr = 0
+	if f := s.pages(); f.min_block <= f.max_block {
+		s.min_addr, s.max_addr = f.addr(f.min_block), f.addr(f.max_block)
+	}
+	log.Printf("Min:%x, max:%x\n", s.min_addr, s.max_addr)
+}
+
+// writeReuseCSVs writes the stack distance histogram and the miss ratio curve
+// of every reuse granularity, either writer may be nil
+func (s *Stats) writeReuseCSVs(histWriter, mrcWriter *csv.Writer) error {
+	if histWriter != nil {
+		histWriter.Write(reuse.HistogramCSVHeader)
+	}
+	if mrcWriter != nil {
+		mrcWriter.Write(reuse.MissRatioCSVHeader)
+	}
+	for _, r := range s.reuse {
+		label := granularity{shift: uint(bits.TrailingZeros64(r.BlockSize()))}.String()
+		log.Printf("Reuse %s:\tcold misses: %d\tsampling rate: %g\n", label, r.ColdMisses(), r.Rate())
+		if histWriter != nil {
+			r.WriteHistogramCSV(histWriter, label)
+		}
+		if mrcWriter != nil {
+			r.WriteMissRatioCSV(mrcWriter, label)
+		}
+	}
+	for _, w := range []*csv.Writer{histWriter, mrcWriter} {
+		if w == nil {
+			continue
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heatmapGrid groups the heatmap into at most buckets address buckets over
+// the accessed address range
+func (s *Stats) heatmapGrid(buckets int, reset bool) *heatmapGrid {
+	s.calcMinMax()
+	if s.min_addr > s.max_addr {
+		return &heatmapGrid{}
+	}
+	if s.heatmap.granularity != s.pages().granularity {
+		log.Printf("Heatmap pages were merged into blocks of %s to fit -heatmappages\n", s.heatmap.granularity)
+	}
+	return s.heatmap.grid(s.min_addr, s.max_addr, buckets, reset)
+}
+
+// outputHeatmapCSV writes the accesses per address bucket of every window
+func (s *Stats) outputHeatmapCSV(csvWriter *csv.Writer, buckets int, reset bool) error {
+	grid := s.heatmapGrid(buckets, reset)
+	csvWriter.Write([]string{"timestamp", "address", "reads", "writes", "fetches"})
+	for i, counts := range grid.counts {
+		for bucket, c := range counts {
+			csvWriter.Write([]string{
+				strconv.FormatUint(grid.times[i], 10),
+				strconv.FormatUint(grid.bucketAddr(bucket), 10),
+				strconv.FormatUint(c.total_reads, 10),
+				strconv.FormatUint(c.total_writes, 10),
+				strconv.FormatUint(c.total_fetch, 10),
+			})
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
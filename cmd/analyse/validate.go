@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// validation compares the requests the simulated hierarchy sends to memory
+// with the miss stream recorded by gem5, line by line within every window
+type validation struct {
+	line_size            uint64
+	simulated_misses     map[uint64]uint32 // Per line in the current window
+	recorded_misses      map[uint64]uint32
+	simulated_writebacks map[uint64]uint32
+	recorded_writebacks  map[uint64]uint32
+	misses               agreement // Totals of all windows
+	writebacks           agreement
+	csvWriter            *csv.Writer // Optional per window output
+}
+
+// agreement counts the simulated and recorded requests of a window, a
+// simulated and a recorded request to the same line match
+type agreement struct {
+	matches        uint64
+	simulated_only uint64
+	recorded_only  uint64
+}
+
+func (a *agreement) add(b agreement) {
+	a.matches += b.matches
+	a.simulated_only += b.simulated_only
+	a.recorded_only += b.recorded_only
+}
+
+// ratio returns the fraction of all requests that matched
+func (a agreement) ratio() float64 {
+	total := a.matches + a.simulated_only + a.recorded_only
+	if total == 0 {
+		return 1
+	}
+	return float64(a.matches) / float64(total)
+}
+
+func compareLines(simulated, recorded map[uint64]uint32) agreement {
+	var a agreement
+	for line, n := range simulated {
+		m := recorded[line]
+		if m > n {
+			m = n
+		}
+		a.matches += uint64(m)
+		a.simulated_only += uint64(n - m)
+	}
+	for line, n := range recorded {
+		if m := simulated[line]; m < n {
+			a.recorded_only += uint64(n - m)
+		}
+	}
+	return a
+}
+
+func newValidation(lineSize uint64, csvWriter *csv.Writer) *validation {
+	v := &validation{line_size: lineSize, csvWriter: csvWriter}
+	v.reset()
+	return v
+}
+
+func (v *validation) reset() {
+	v.simulated_misses = map[uint64]uint32{}
+	v.recorded_misses = map[uint64]uint32{}
+	v.simulated_writebacks = map[uint64]uint32{}
+	v.recorded_writebacks = map[uint64]uint32{}
+}
+
+// addSimulated records a request of the simulated hierarchy to memory
+func (v *validation) addSimulated(cmd trace.Command, addr uint64) {
+	if cmd == trace.WritebackDirty || cmd == trace.WriteReq {
+		v.simulated_writebacks[addr/v.line_size]++
+	} else {
+		v.simulated_misses[addr/v.line_size]++
+	}
+}
+
+// addRecorded records an access of the gem5 miss stream, misses of
+// instruction fetches keep the fetch flag of the request
+func (v *validation) addRecorded(a trace.Access) {
+	if a.Kind.IsWrite() {
+		v.recorded_writebacks[a.Addr/v.line_size]++
+	} else if a.Kind.IsRead() || a.Kind == trace.Fetch {
+		v.recorded_misses[a.Addr/v.line_size]++
+	}
+}
+
+var validationCSVHeader = []string{
+	"timestamp",
+	"miss_matches", "simulated_only_misses", "gem5_only_misses", "miss_agreement",
+	"writeback_matches", "simulated_only_writebacks", "gem5_only_writebacks", "writeback_agreement",
+}
+
+func validationCSVRecord(timestamp uint64, misses, writebacks agreement) []string {
+	return []string{
+		strconv.FormatUint(timestamp, 10),
+		strconv.FormatUint(misses.matches, 10),
+		strconv.FormatUint(misses.simulated_only, 10),
+		strconv.FormatUint(misses.recorded_only, 10),
+		strconv.FormatFloat(misses.ratio(), 'f', 6, 64),
+		strconv.FormatUint(writebacks.matches, 10),
+		strconv.FormatUint(writebacks.simulated_only, 10),
+		strconv.FormatUint(writebacks.recorded_only, 10),
+		strconv.FormatFloat(writebacks.ratio(), 'f', 6, 64),
+	}
+}
+
+// writeOut compares the window ending at timestamp and starts a new one
+func (v *validation) writeOut(timestamp uint64) {
+	misses := compareLines(v.simulated_misses, v.recorded_misses)
+	writebacks := compareLines(v.simulated_writebacks, v.recorded_writebacks)
+	v.misses.add(misses)
+	v.writebacks.add(writebacks)
+	if v.csvWriter != nil {
+		v.csvWriter.Write(validationCSVRecord(timestamp, misses, writebacks))
+	}
+	v.reset()
+}
+
+func (v *validation) print() {
+	log.Printf("Validation misses:\tmatches: %d\tsimulated only: %d\tgem5 only: %d\tagreement: %f\n", v.misses.matches, v.misses.simulated_only, v.misses.recorded_only, v.misses.ratio())
+	log.Printf("Validation writebacks:\tmatches: %d\tsimulated only: %d\tgem5 only: %d\tagreement: %f\n", v.writebacks.matches, v.writebacks.simulated_only, v.writebacks.recorded_only, v.writebacks.ratio())
+}
+
+// startValidation compares the memory traffic of the hierarchy with the miss
+// stream inputs, csvWriter may be nil
+func (s *Stats) startValidation(csvWriter *csv.Writer) error {
+	if s.hierarchy == nil {
+		return fmt.Errorf("Validation needs a cache hierarchy, add levels with -level")
+	}
+	s.validation = newValidation(s.hierarchy.LineSize(), csvWriter)
+	onMemory := s.hierarchy.OnMemory
+	s.hierarchy.OnMemory = func(cmd trace.Command, addr uint64) {
+		s.validation.addSimulated(cmd, addr)
+		if onMemory != nil {
+			onMemory(cmd, addr)
+		}
+	}
+	return nil
+}
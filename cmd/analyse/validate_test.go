@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/doriandekoning/memory-trace-analyser/cache"
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+// validationAccesses returns fetches and data accesses of one CPU touching
+// more lines than the caches hold
+func validationAccesses() []trace.Access {
+	var accesses []trace.Access
+	for i := uint64(0); i < 20000; i++ {
+		kind := trace.Read
+		addr := 0x100000 + (i*7%3000)*64
+		switch i % 4 {
+		case 0:
+			kind, addr = trace.Fetch, (i*13%2000)*64
+		case 1:
+			kind = trace.Write
+		}
+		accesses = append(accesses, trace.Access{Tick: i * 1000, Time: i, Addr: addr, Size: 8, Kind: kind})
+	}
+	return accesses
+}
+
+func validationLevels(t *testing.T) []cache.LevelConfig {
+	var levels []cache.LevelConfig
+	for _, desc := range []string{"type=inst,size=16KiB", "type=data,size=16KiB", "level=2,size=64KiB,shared=true,inclusion=inclusive"} {
+		cfg, err := cache.ParseLevelConfig(desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		levels = append(levels, cfg)
+	}
+	return levels
+}
+
+// TestValidateOwnMemoryTrace validates a hierarchy against the memory trace it
+// wrote itself, which has to agree completely, including the fetch misses
+func TestValidateOwnMemoryTrace(t *testing.T) {
+	commands, err := trace.Gem5Commands(trace.DefaultGem5Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discard := csv.NewWriter(ioutil.Discard)
+	var memTrace bytes.Buffer
+	record := newStats(discard, trace.FullMemoryMap(), nil, false)
+	if err := record.setHierarchy(validationLevels(t), commands, &memTrace); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range validationAccesses() {
+		record.processAccess(a)
+	}
+	if err := record.hierarchy.closeTrace(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := trace.NewGem5Reader(bufio.NewReader(&memTrace), trace.Options{Commands: commands})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var misses []trace.Access
+	fetchMisses := 0
+	for {
+		a, err := in.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Kind == trace.Fetch {
+			fetchMisses++
+		}
+		a.Miss = true
+		misses = append(misses, a)
+	}
+	if fetchMisses == 0 {
+		t.Fatal("Memory trace contains no fetch misses")
+	}
+
+	replay := newStats(discard, trace.FullMemoryMap(), nil, false)
+	if err := replay.setHierarchy(validationLevels(t), commands, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.startValidation(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range validationAccesses() {
+		replay.processAccess(a)
+	}
+	for _, a := range misses {
+		replay.processAccess(a)
+	}
+	replay.flush()
+
+	v := replay.validation
+	if v.misses.matches != record.hierarchy.MemoryReads || v.misses.simulated_only != 0 || v.misses.recorded_only != 0 {
+		t.Errorf("Misses: %+v, expected %d matches", v.misses, record.hierarchy.MemoryReads)
+	}
+	if v.writebacks.matches != record.hierarchy.MemoryWrites || v.writebacks.simulated_only != 0 || v.writebacks.recorded_only != 0 {
+		t.Errorf("Writebacks: %+v, expected %d matches", v.writebacks, record.hierarchy.MemoryWrites)
+	}
+}
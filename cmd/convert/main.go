@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/doriandekoning/memory-trace-analyser/trace"
+)
+
+var cmdCounts [trace.NumCommands]uint64
+
+func main() {
+	var inputs trace.InputSpecs
+	flag.Var(&inputs, "input", "Comma separated input files or a single input description 'path=<file>,cpu=<id>,role=<data|ifetch|miss>', may be repeated. Inputs without cpu or role are the last level cache miss stream followed by the instruction and data stream of every CPU")
+	inputManifest := flag.String("inputmanifest", "", "File listing one input description per line")
+	qemuTraceOut := flag.String("out", "", "Gem trace ouput location for qemu trace")
+	onError := flag.String("on-error", "stop", "Handling of damaged trace records (stop/skip/resync)")
+	maxMessageSize := flag.Int("maxmessagesize", trace.DefaultMaxMessageSize, "Largest gem5 packet in bytes that is accepted")
+	skip := flag.Uint64("skip", 0, "Amount of accesses to skip at the start of the merged inputs")
+	limit := flag.Uint64("limit", 0, "Maximum amount of accesses read from the merged inputs after skipping, 0 means no limit")
+	startTick := flag.Uint64("start-tick", 0, "Ignore accesses before this tick")
+	endTick := flag.Uint64("end-tick", 0, "Stop reading an input at the first access at or after this tick, 0 means no end")
+	instructionSource := flag.String("instructions", "fetch", "Instruction count source (fetch/pc/input) for the per kilo instruction statistics written to stdout, fetch counts the fetch records, pc the program counter changes of every CPU and input reads -instructioninput")
+	instructionInput := flag.String("instructioninput", "", "File with '<tick>,<instructions>' lines giving the total amount of retired instructions at a tick, implies -instructions input")
+	windowInstructions := flag.Uint64("windowinstructions", 10000000, "Write a row with the misses, reads and writes per kilo instruction to stdout every given amount of instructions")
+	gem5Version := flag.String("gem5version", trace.DefaultGem5Version, fmt.Sprintf("gem5 version used to number the commands (%s)", strings.Join(trace.Gem5Versions(), "/")))
+	flag.Parse()
+	if *inputManifest != "" {
+		if err := inputs.ReadManifest(*inputManifest); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(inputs) == 0 {
+		log.Fatal("No input given")
+	}
+	inputs.Resolve(trace.MissStreamLayout)
+	var opts trace.Options
+	var err error
+	opts.Commands, err = trace.Gem5Commands(*gem5Version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.OnError, err = trace.ParseErrorMode(*onError)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.MaxMessageSize = *maxMessageSize
+	opts.Skip, opts.Limit = *skip, *limit
+	opts.StartTick, opts.EndTick = *startTick, *endTick
+	if opts.EndTick != 0 && opts.EndTick <= opts.StartTick {
+		log.Fatal("The end tick has to be after the start tick")
+	}
+
+	source, err := trace.ParseInstructionSource(*instructionSource)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *instructionInput != "" {
+		source = trace.InputInstructions
+	}
+	if source == trace.NoInstructions {
+		log.Fatal("The per kilo instruction statistics need an instruction source, -instructions can not be none")
+	}
+	instructions, err := trace.OpenInstructionCounter(source, *instructionInput)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *windowInstructions == 0 {
+		log.Fatal("The instruction window has to be positive")
+	}
+
+	log.Println("Writing output to: ", *qemuTraceOut)
+	output, err := os.Create(*qemuTraceOut)
+	if err != nil {
+		log.Fatal("Unable to open output: ", err)
+	}
+
+	log.Print("Writing output to:", *qemuTraceOut)
+	defer output.Close()
+
+	bufferedOutput := bufio.NewWriter(output)
+
+	log.Printf("Reading gem5 trace")
+	processGem5Trace(inputs, opts, bufferedOutput, instructions, *windowInstructions)
+}
+
+// kiCounts counts the misses of the miss stream and the reads and writes of
+// the request streams during a window of instructions
+type kiCounts struct {
+	misses, readMisses, writeMisses uint64
+	reads, writes                   uint64
+}
+
+func (c kiCounts) print(instructions, windowInstructions uint64) {
+	fmt.Printf("%d,%d,%f,%f,%f,%f,%f\n", instructions, c.misses,
+		trace.PerKilo(c.misses, windowInstructions),
+		trace.PerKilo(c.readMisses, windowInstructions),
+		trace.PerKilo(c.writeMisses, windowInstructions),
+		trace.PerKilo(c.reads, windowInstructions),
+		trace.PerKilo(c.writes, windowInstructions))
+}
+
+func processGem5Trace(specs trace.InputSpecs, opts trace.Options, out *bufio.Writer, instructions *trace.InstructionCounter, windowInstructions uint64) {
+	inputs := []trace.Reader{}
+	var tickFreq uint64
+	mixedFreqs := false
+	cpus := 0
+	for _, spec := range specs {
+		in, err := trace.OpenGem5(spec.Path, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer in.Close()
+		defer func(path string, in *trace.Gem5Reader) {
+			if in.Skipped > 0 {
+				log.Printf("Skipped %d damaged packets (%d bytes) in %s\n", in.Skipped, in.SkippedBytes, path)
+			}
+		}(spec.Path, in)
+		log.Printf("%d:%s\n", len(inputs), spec)
+		if spec.Role != trace.RoleMiss && spec.CPU >= cpus {
+			cpus = spec.CPU + 1
+		}
+		inputs = append(inputs, in)
+
+		log.Println("Tick frequency:", in.Header.GetTickFreq())
+		if tickFreq != 0 && tickFreq != in.Header.GetTickFreq() {
+			mixedFreqs = true
+		}
+		tickFreq = in.Header.GetTickFreq()
+		log.Println("Objid:", in.Header.GetObjId())
+	}
+	if mixedFreqs && (opts.StartTick != 0 || opts.EndTick != 0) {
+		log.Fatal("Inputs have different tick frequencies, -start-tick and -end-tick are ambiguous")
+	}
+	if mixedFreqs {
+		// Write nanoseconds so all inputs share the same time base
+		log.Println("Inputs have different tick frequencies, converting ticks to nanoseconds")
+		tickFreq = 1e9
+	}
+	merger := trace.Select(trace.NewMerger(inputs...), opts)
+	qemuOut, err := trace.NewQemuWriter(out, trace.QemuHeader{
+		Layout:   trace.LayoutFull,
+		CPUs:     uint32(cpus),
+		TickFreq: tickFreq,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Flush()
+
+	var window kiCounts
+	windowStart := uint64(0)
+	fmt.Println("instructions,misses,mpki,read_mpki,write_mpki,reads_per_ki,writes_per_ki")
+
+	readMiss := 0
+	writeMiss := 0
+	for {
+		packet, err := merger.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Unable to get next packet:", err)
+			}
+			break
+		}
+		spec := specs[packet.Input]
+		cmdCounts[packet.Cmd]++
+		if spec.Role == trace.RoleMiss {
+			// Misses of instruction fetches keep the fetch flag of the request
+			if packet.Kind.IsRead() || packet.Kind.IsWrite() || packet.Kind == trace.Fetch {
+				window.misses++
+				if packet.Kind.IsWrite() {
+					window.writeMisses++
+					writeMiss++
+				} else {
+					readMiss++
+					window.readMisses++
+				}
+			}
+		} else {
+			spec.Apply(&packet)
+			instructions.Add(packet)
+			if packet.Kind.IsWrite() {
+				window.writes++
+			} else if packet.Kind.IsRead() {
+				window.reads++
+			}
+			if mixedFreqs {
+				packet.Tick = packet.Time
+			}
+			writeQemuEvent(qemuOut, packet)
+
+			if instructions.Count()-windowStart >= windowInstructions {
+				window.print(instructions.Count(), instructions.Count()-windowStart)
+				window = kiCounts{}
+				windowStart = instructions.Count()
+			}
+		}
+	}
+	if instructions.Count() > windowStart {
+		window.print(instructions.Count(), instructions.Count()-windowStart)
+	}
+	for cmd, count := range cmdCounts {
+		if count > 0 {
+			log.Printf("%s:%d\n", trace.Command(cmd), count)
+		}
+	}
+	log.Println("Read miss:", readMiss)
+	log.Println("Write miss:", writeMiss)
+	log.Printf("Instructions (%s): %d\n", instructions.Source(), instructions.Count())
+	log.Printf("MPKI: %f\n", trace.PerKilo(uint64(readMiss+writeMiss), instructions.Count()))
+}
+
+func writeQemuEvent(out *trace.QemuWriter, packet trace.Access) {
+	if !packet.Kind.IsRead() && !packet.Kind.IsWrite() && packet.Kind != trace.Fetch {
+		return
+	}
+	if err := out.Write(packet); err != nil {
+		log.Fatal(err)
+	}
+}
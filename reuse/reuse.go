@@ -0,0 +1,272 @@
+// Package reuse computes LRU stack distances, the amount of distinct blocks
+// accessed between two accesses to the same block. The distribution of the
+// distances gives the miss ratio of a fully associative LRU cache of any size
+// in a single pass over a trace.
+package reuse
+
+import (
+	"encoding/csv"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// Distances below exactBins have their own bin, larger distances are grouped
+// into subBins bins per power of two
+const (
+	exactBins = 64
+	subBits   = 4
+	subBins   = 1 << subBits
+	numBins   = exactBins + (64-6)*subBins
+)
+
+func binOf(d uint64) int {
+	if d < exactBins {
+		return int(d)
+	}
+	e := uint(bits.Len64(d) - 1)
+	return exactBins + int(e-6)*subBins + int(d>>(e-subBits)&(subBins-1))
+}
+
+// binRange returns the smallest and largest distance of bin
+func binRange(bin int) (uint64, uint64) {
+	if bin < exactBins {
+		return uint64(bin), uint64(bin)
+	}
+	e := uint(6 + (bin-exactBins)/subBins)
+	sub := uint64((bin - exactBins) % subBins)
+	start := (subBins + sub) << (e - subBits)
+	return start, start + 1<<(e-subBits) - 1
+}
+
+// Sampling uses the hash of a block, a block is sampled when the low
+// samplingBits bits of its hash are below the threshold
+const samplingBits = 24
+
+// Analyser computes the stack distance of every access. With a sampling rate
+// below one only the accesses to a spatially sampled subset of the blocks are
+// tracked (SHARDS) and the distances are scaled accordingly.
+type Analyser struct {
+	shift     uint
+	rate      float64
+	threshold uint64
+	last      map[uint64]uint32 // Time of the last access to every block
+	tree      fenwick           // Marks the times that are the last access of a block
+	now       uint32
+	bins      [numBins]uint64
+	cold      uint64 // First accesses to a block
+	sampled   uint64 // Accesses that were tracked
+	total     uint64
+}
+
+// New returns an analyser for blocks of 1<<shift bytes that samples the given
+// fraction of the blocks, a rate of one (or more) tracks every block
+func New(shift uint, rate float64) *Analyser {
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	return &Analyser{
+		shift:     shift,
+		rate:      rate,
+		threshold: uint64(math.Ceil(rate * (1 << samplingBits))),
+		last:      map[uint64]uint32{},
+		tree:      newFenwick(1 << 16),
+	}
+}
+
+// BlockSize returns the size of the blocks in bytes
+func (a *Analyser) BlockSize() uint64 {
+	return 1 << a.shift
+}
+
+// Rate returns the fraction of blocks that is sampled
+func (a *Analyser) Rate() float64 {
+	return a.rate
+}
+
+// Add records an access to addr
+func (a *Analyser) Add(addr uint64) {
+	a.total++
+	block := addr >> a.shift
+	if a.rate < 1 && Hash64(block)&(1<<samplingBits-1) >= a.threshold {
+		return
+	}
+	a.sampled++
+	if int(a.now)+1 >= a.tree.size() {
+		a.compact()
+	}
+	a.now++
+	prev, ok := a.last[block]
+	if ok {
+		d := uint64(a.tree.sum(a.now-1) - a.tree.sum(prev))
+		if a.rate < 1 {
+			d = uint64(float64(d) / a.rate)
+		}
+		a.bins[binOf(d)]++
+		a.tree.add(prev, -1)
+	} else {
+		a.cold++
+	}
+	a.tree.add(a.now, 1)
+	a.last[block] = a.now
+}
+
+// compact renumbers the last access times of the blocks, keeping their order,
+// so the time fits in a tree of twice the amount of blocks
+func (a *Analyser) compact() {
+	blocks := make([]uint64, 0, len(a.last))
+	for block := range a.last {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return a.last[blocks[i]] < a.last[blocks[j]] })
+	size := 2 * (len(blocks) + 1)
+	if size < 1<<16 {
+		size = 1 << 16
+	}
+	if uint64(size) > math.MaxUint32 {
+		panic("reuse: too many distinct blocks")
+	}
+	a.tree = newFenwick(size)
+	for i, block := range blocks {
+		a.last[block] = uint32(i + 1)
+		a.tree.add(uint32(i+1), 1)
+	}
+	a.now = uint32(len(blocks))
+}
+
+// Bin is a range of stack distances and the (estimated) amount of accesses
+// with a distance in it
+type Bin struct {
+	Start, End uint64
+	Count      uint64
+}
+
+func (a *Analyser) scale(count uint64) uint64 {
+	return uint64(math.Round(float64(count) / a.rate))
+}
+
+// Histogram returns the non empty bins of the stack distance histogram,
+// first accesses to a block are returned as ColdMisses
+func (a *Analyser) Histogram() []Bin {
+	var hist []Bin
+	for bin, count := range a.bins {
+		if count == 0 {
+			continue
+		}
+		start, end := binRange(bin)
+		hist = append(hist, Bin{Start: start, End: end, Count: a.scale(count)})
+	}
+	return hist
+}
+
+// ColdMisses returns the (estimated) amount of first accesses to a block
+func (a *Analyser) ColdMisses() uint64 {
+	return a.scale(a.cold)
+}
+
+// Total returns the amount of accesses added
+func (a *Analyser) Total() uint64 {
+	return a.total
+}
+
+// MissRatio returns the miss ratio of a fully associative LRU cache holding
+// blocks blocks
+func (a *Analyser) MissRatio(blocks uint64) float64 {
+	if a.sampled == 0 {
+		return 0
+	}
+	// The difference between the expected and actual amount of sampled
+	// accesses is attributed to the smallest distances (SHARDS_adj)
+	expected := a.rate * float64(a.total)
+	misses := a.cold
+	for bin := binOf(blocks); bin < numBins; bin++ {
+		if start, _ := binRange(bin); start >= blocks {
+			misses += a.bins[bin]
+		}
+	}
+	if start, _ := binRange(binOf(blocks)); start < blocks {
+		// Only part of the bin is at least blocks, assume a uniform spread
+		bin := binOf(blocks)
+		_, end := binRange(bin)
+		misses += a.bins[bin] * (end - blocks + 1) / (end - start + 1)
+	}
+	return math.Min(1, float64(misses)/expected)
+}
+
+// WriteHistogramCSV writes the histogram with one row per bin, the first row
+// holds the cold misses
+func (a *Analyser) WriteHistogramCSV(csvWriter *csv.Writer, label string) {
+	csvWriter.Write([]string{label, "cold", "cold", strconv.FormatUint(a.ColdMisses(), 10)})
+	for _, bin := range a.Histogram() {
+		csvWriter.Write([]string{
+			label,
+			strconv.FormatUint(bin.Start, 10),
+			strconv.FormatUint(bin.End, 10),
+			strconv.FormatUint(bin.Count, 10),
+		})
+	}
+}
+
+// WriteMissRatioCSV writes the miss ratio curve, with a row for every cache
+// size at which a histogram bin starts up to the largest distance seen
+func (a *Analyser) WriteMissRatioCSV(csvWriter *csv.Writer, label string) {
+	last := 0
+	for bin, count := range a.bins {
+		if count > 0 {
+			last = bin
+		}
+	}
+	for bin := 1; bin <= last+1 && bin < numBins; bin++ {
+		blocks, _ := binRange(bin)
+		csvWriter.Write([]string{
+			label,
+			strconv.FormatUint(blocks*a.BlockSize(), 10),
+			strconv.FormatUint(blocks, 10),
+			strconv.FormatFloat(a.MissRatio(blocks), 'f', 6, 64),
+		})
+	}
+}
+
+// HistogramCSVHeader and MissRatioCSVHeader are the headers of the CSV
+// outputs
+var (
+	HistogramCSVHeader = []string{"granularity", "distance_start", "distance_end", "count"}
+	MissRatioCSVHeader = []string{"granularity", "cache_size", "cache_blocks", "miss_ratio"}
+)
+
+// Hash64 mixes the bits of x, it is the finalizer of MurmurHash3
+func Hash64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// fenwick is a binary indexed tree over the positions 1 to size-1
+type fenwick []int32
+
+func newFenwick(size int) fenwick {
+	return make(fenwick, size)
+}
+
+func (f fenwick) size() int {
+	return len(f)
+}
+
+func (f fenwick) add(pos uint32, delta int32) {
+	for i := int(pos); i < len(f); i += i & -i {
+		f[i] += delta
+	}
+}
+
+// sum returns the sum of the positions up to and including pos
+func (f fenwick) sum(pos uint32) int32 {
+	var s int32
+	for i := int(pos); i > 0; i -= i & -i {
+		s += f[i]
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors describing why a record could not be read, they are wrapped in a
+// RecordError carrying the offset of the record
+var (
+	ErrTruncated = errors.New("truncated record")
+	ErrBadVarint = errors.New("bad varint")
+	ErrUnmarshal = errors.New("unable to unmarshal")
+	ErrTooLarge  = errors.New("record exceeds maximum message size")
+)
+
+// RecordError is returned when the record starting at Offset is damaged
+type RecordError struct {
+	Offset int64 // Byte offset of the record in the (decompressed) trace
+	Err    error // One of ErrTruncated, ErrBadVarint, ErrUnmarshal or ErrTooLarge
+	Cause  error // Underlying error, if any
+	length int   // Length of the damaged record, 0 if unknown
+}
+
+func (e *RecordError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%v at offset %d: %v", e.Err, e.Offset, e.Cause)
+	}
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorMode determines how a reader handles damaged records
+type ErrorMode uint8
+
+const (
+	// StopOnError returns the error, ending the trace
+	StopOnError ErrorMode = iota
+	// SkipOnError skips damaged records whose length is known and treats a
+	// truncated record at the end of the trace as end of file
+	SkipOnError
+	// ResyncOnError scans forward byte by byte until a valid record is found
+	ResyncOnError
+)
+
+var errorModeNames = [...]string{
+	StopOnError:   "stop",
+	SkipOnError:   "skip",
+	ResyncOnError: "resync",
+}
+
+func (m ErrorMode) String() string {
+	if int(m) < len(errorModeNames) {
+		return errorModeNames[m]
+	}
+	return "stop"
+}
+
+// ParseErrorMode returns the error mode with the given name
+func ParseErrorMode(name string) (ErrorMode, error) {
+	for m, n := range errorModeNames {
+		if n == name {
+			return ErrorMode(m), nil
+		}
+	}
+	return StopOnError, fmt.Errorf("Unknown error mode %q (stop/skip/resync)", name)
+}
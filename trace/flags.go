@@ -0,0 +1,83 @@
+package trace
+
+import "strings"
+
+// RequestFlags are the gem5 Request::Flags recorded in the flags field of a
+// packet
+type RequestFlags uint32
+
+// gem5 request flags
+const (
+	FlagInstFetch      RequestFlags = 0x00000100
+	FlagPhysical       RequestFlags = 0x00000200
+	FlagUncacheable    RequestFlags = 0x00000400
+	FlagStrictOrder    RequestFlags = 0x00000800
+	FlagPrivileged     RequestFlags = 0x00008000
+	FlagCacheBlockZero RequestFlags = 0x00010000
+	FlagAcquire        RequestFlags = 0x00020000
+	FlagRelease        RequestFlags = 0x00040000
+	FlagNoAccess       RequestFlags = 0x00080000
+	FlagLockedRMW      RequestFlags = 0x00100000
+	FlagLLSC           RequestFlags = 0x00200000
+	FlagMemSwap        RequestFlags = 0x00400000
+	FlagMemSwapCond    RequestFlags = 0x00800000
+	FlagPrefetch       RequestFlags = 0x01000000
+	FlagPFExclusive    RequestFlags = 0x02000000
+	FlagEvictNext      RequestFlags = 0x04000000
+	FlagSecure         RequestFlags = 0x10000000
+	FlagPTWalk         RequestFlags = 0x20000000
+)
+
+var flagNames = []struct {
+	flag RequestFlags
+	name string
+}{
+	{FlagInstFetch, "INST_FETCH"},
+	{FlagPhysical, "PHYSICAL"},
+	{FlagUncacheable, "UNCACHEABLE"},
+	{FlagStrictOrder, "STRICT_ORDER"},
+	{FlagPrivileged, "PRIVILEGED"},
+	{FlagCacheBlockZero, "CACHE_BLOCK_ZERO"},
+	{FlagAcquire, "ACQUIRE"},
+	{FlagRelease, "RELEASE"},
+	{FlagNoAccess, "NO_ACCESS"},
+	{FlagLockedRMW, "LOCKED_RMW"},
+	{FlagLLSC, "LLSC"},
+	{FlagMemSwap, "MEM_SWAP"},
+	{FlagMemSwapCond, "MEM_SWAP_COND"},
+	{FlagPrefetch, "PREFETCH"},
+	{FlagPFExclusive, "PF_EXCLUSIVE"},
+	{FlagEvictNext, "EVICT_NEXT"},
+	{FlagSecure, "SECURE"},
+	{FlagPTWalk, "PT_WALK"},
+}
+
+// Has reports whether all flags in mask are set
+func (f RequestFlags) Has(mask RequestFlags) bool {
+	return f&mask == mask
+}
+
+func (f RequestFlags) String() string {
+	var names []string
+	for _, n := range flagNames {
+		if f.Has(n.flag) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// refineKind refines the kind derived from the command using the request
+// flags, reads that are instruction fetches or prefetches are marked as such
+func (f RequestFlags) refineKind(kind Kind) Kind {
+	if kind != Read {
+		return kind
+	}
+	if f.Has(FlagInstFetch) {
+		return Fetch
+	}
+	if f.Has(FlagPrefetch) {
+		return Prefetch
+	}
+	return kind
+}
@@ -0,0 +1,319 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	pb "github.com/doriandekoning/memory-trace-analyser/proto"
+	"github.com/gogo/protobuf/proto"
+)
+
+const gem5Magic = "gem5"
+
+// Gem5Reader reads a protobuf encoded packet trace as written by gem5
+type Gem5Reader struct {
+	in       *bufio.Reader
+	closer   io.Closer
+	buffer   []byte
+	packet   pb.Packet
+	offset   int64
+	lastTick uint64
+	commands *CommandTable
+	onError  ErrorMode
+	maxSize  int
+	ticks    tickRange
+	Header   pb.PacketHeader
+	// Skipped is the amount of damaged packets skipped and SkippedBytes the
+	// amount of bytes discarded because of them
+	Skipped      int
+	SkippedBytes int64
+}
+
+// OpenGem5 opens the gem5 trace located at path, gzipped traces are
+// decompressed on the fly
+func OpenGem5(path string, opts Options) (*Gem5Reader, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open input: %w", err)
+	}
+	r, err := NewGem5Reader(f.Reader, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// NewGem5Reader reads the file header from in and returns a reader positioned
+// at the first packet
+func NewGem5Reader(in *bufio.Reader, opts Options) (*Gem5Reader, error) {
+	magic := make([]byte, len(gem5Magic))
+	if _, err := io.ReadFull(in, magic); err != nil {
+		return nil, fmt.Errorf("Unable to read header: %w", err)
+	}
+	if string(magic) != gem5Magic {
+		return nil, fmt.Errorf("Input not recognized")
+	}
+	r := &Gem5Reader{
+		in:       in,
+		buffer:   make([]byte, 1024),
+		offset:   int64(len(gem5Magic)),
+		commands: opts.Commands,
+		onError:  opts.OnError,
+		maxSize:  opts.MaxMessageSize,
+		ticks:    newTickRange(opts),
+	}
+	if r.commands == nil {
+		r.commands = gem5Versions[DefaultGem5Version]
+	}
+	if r.maxSize <= 0 {
+		r.maxSize = DefaultMaxMessageSize
+	}
+	if err := r.readMessage(&r.Header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("Unable to read trace header: %w", err)
+	}
+	return r, nil
+}
+
+// ReadPacket reads the next raw packet into pkt, it returns io.EOF when the
+// end of the trace is reached at a packet boundary and a *RecordError when a
+// damaged packet is encountered that is not handled according to the error
+// mode
+func (r *Gem5Reader) ReadPacket(pkt *pb.Packet) error {
+	err := r.readMessage(pkt)
+	for err != nil && err != io.EOF && r.onError != StopOnError {
+		var recErr *RecordError
+		if !errors.As(err, &recErr) {
+			return err
+		}
+		if r.onError == SkipOnError {
+			if errors.Is(err, ErrTruncated) {
+				r.Skipped++
+				return io.EOF
+			}
+			if recErr.length == 0 {
+				return err
+			}
+			r.discard(recErr.length)
+			r.Skipped++
+			err = r.readMessage(pkt)
+			continue
+		}
+		r.Skipped++
+		err = r.resync(pkt)
+	}
+	if err == nil {
+		r.lastTick = pkt.GetTick()
+	}
+	return err
+}
+
+// resync discards bytes until a packet is found that can be decoded, is
+// encoded the way gem5 encodes it and does not go back in time. Packets that
+// decode but are not encoded canonically usually span several records, whose
+// fields are merged by the decoder.
+func (r *Gem5Reader) resync(pkt *pb.Packet) error {
+	// Only consider messages that can be decoded without consuming them
+	limit := r.in.Size() - binary.MaxVarintLen64
+	if limit > r.maxSize {
+		limit = r.maxSize
+	}
+	for {
+		r.discard(1)
+		length, err := r.peekMessage(pkt, limit)
+		if err == io.EOF {
+			return err
+		}
+		var recErr *RecordError
+		if err != nil && !errors.As(err, &recErr) {
+			return err
+		}
+		if err == nil && pkt.GetTick() >= r.lastTick && canonicalSize(pkt) == length {
+			r.in.Discard(length)
+			r.offset += int64(length)
+			return nil
+		}
+	}
+}
+
+// canonicalSize returns the size of msg including its length when encoded
+func canonicalSize(msg proto.Message) int {
+	size := proto.Size(msg)
+	return proto.SizeVarint(uint64(size)) + size
+}
+
+func (r *Gem5Reader) discard(n int) {
+	n, _ = r.in.Discard(n)
+	r.offset += int64(n)
+	r.SkippedBytes += int64(n)
+}
+
+// readMessage decodes the length delimited message at the current position
+// into msg and consumes it
+func (r *Gem5Reader) readMessage(msg proto.Message) error {
+	length, err := r.peekMessage(msg, r.maxSize)
+	if err != nil {
+		return err
+	}
+	r.in.Discard(length)
+	r.offset += int64(length)
+	return nil
+}
+
+// peekMessage decodes the length delimited message at the current position
+// into msg and returns the amount of bytes it occupies without consuming
+// them. Messages that do not fit in the read buffer are consumed while
+// decoding, in which case the returned length is zero. Messages larger than
+// limit bytes are rejected.
+func (r *Gem5Reader) peekMessage(msg proto.Message, limit int) (int, error) {
+	head, err := r.in.Peek(binary.MaxVarintLen64)
+	if len(head) == 0 {
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, &RecordError{Offset: r.offset, Err: ErrTruncated, Cause: err}
+	}
+	size, n := proto.DecodeVarint(head)
+	if n == 0 {
+		if len(head) < binary.MaxVarintLen64 {
+			return 0, &RecordError{Offset: r.offset, Err: ErrTruncated, Cause: err}
+		}
+		return 0, &RecordError{Offset: r.offset, Err: ErrBadVarint}
+	}
+	if size > uint64(limit) {
+		err := &RecordError{Offset: r.offset, Err: ErrTooLarge, Cause: fmt.Errorf("length %d, maximum %d", size, limit)}
+		if size < math.MaxInt32 {
+			err.length = n + int(size)
+		}
+		return 0, err
+	}
+	total := n + int(size)
+	if total > r.in.Size() {
+		// Too large to peek, consume the message while reading it
+		start := r.offset
+		r.in.Discard(n)
+		if int(size) > len(r.buffer) {
+			r.buffer = make([]byte, size)
+		}
+		data := r.buffer[:size]
+		read, err := io.ReadFull(r.in, data)
+		r.offset += int64(n + read)
+		if err != nil {
+			return 0, &RecordError{Offset: start, Err: ErrTruncated, Cause: err}
+		}
+		msg.Reset()
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return 0, &RecordError{Offset: start, Err: ErrUnmarshal, Cause: err}
+		}
+		return 0, nil
+	}
+	data, err := r.in.Peek(total)
+	if err != nil {
+		return 0, &RecordError{Offset: r.offset, Err: ErrTruncated, Cause: err}
+	}
+	msg.Reset()
+	if err := proto.Unmarshal(data[n:], msg); err != nil {
+		return 0, &RecordError{Offset: r.offset, Err: ErrUnmarshal, Cause: err, length: total}
+	}
+	return total, nil
+}
+
+// Next returns the next selected packet as normalized access
+func (r *Gem5Reader) Next() (Access, error) {
+	for {
+		if err := r.ReadPacket(&r.packet); err != nil {
+			return Access{}, err
+		}
+		a := r.access()
+		ok, err := r.ticks.accept(&a)
+		if err != nil {
+			return Access{}, err
+		}
+		if ok {
+			return a, nil
+		}
+	}
+}
+
+func (r *Gem5Reader) access() Access {
+	cmd := r.commands.Decode(r.packet.GetCmd())
+	flags := RequestFlags(r.packet.GetFlags())
+	return Access{
+		Tick:  r.packet.GetTick(),
+		Time:  ticksToNanos(r.packet.GetTick(), r.Header.GetTickFreq()),
+		Addr:  r.packet.GetAddr(),
+		Size:  r.packet.GetSize(),
+		Kind:  flags.refineKind(cmd.Kind()),
+		PC:    r.packet.GetPc(),
+		Flags: flags,
+		PktID: r.packet.GetPktId(),
+		Cmd:   cmd,
+	}
+}
+
+// IDStrings returns the id_strings of the trace header as map
+func (r *Gem5Reader) IDStrings() map[uint32]string {
+	ids := make(map[uint32]string, len(r.Header.GetIdStrings()))
+	for _, entry := range r.Header.GetIdStrings() {
+		ids[entry.GetKey()] = entry.GetValue()
+	}
+	return ids
+}
+
+// Close closes the underlying file if the reader was created by OpenGem5
+func (r *Gem5Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Gem5Writer writes packets in the gem5 protobuf trace format
+type Gem5Writer struct {
+	out *bufio.Writer
+}
+
+// NewGem5Writer writes the file magic and header to out and returns a writer
+// for the packets that follow
+func NewGem5Writer(out io.Writer, header *pb.PacketHeader) (*Gem5Writer, error) {
+	w := &Gem5Writer{out: bufio.NewWriter(out)}
+	if _, err := w.out.WriteString(gem5Magic); err != nil {
+		return nil, fmt.Errorf("Unable to write file header: %w", err)
+	}
+	if err := w.writeMessage(header); err != nil {
+		return nil, fmt.Errorf("Unable to write header: %w", err)
+	}
+	return w, nil
+}
+
+// WritePacket appends pkt to the trace
+func (w *Gem5Writer) WritePacket(pkt *pb.Packet) error {
+	return w.writeMessage(pkt)
+}
+
+// Flush flushes buffered packets to the underlying writer
+func (w *Gem5Writer) Flush() error {
+	return w.out.Flush()
+}
+
+func (w *Gem5Writer) writeMessage(msg proto.Message) error {
+	msgBytes, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal: %w", err)
+	}
+	if _, err := w.out.Write(proto.EncodeVarint(uint64(len(msgBytes)))); err != nil {
+		return fmt.Errorf("Unable to write length: %w", err)
+	}
+	if _, err := w.out.Write(msgBytes); err != nil {
+		return fmt.Errorf("Unable to write message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	pb "github.com/doriandekoning/memory-trace-analyser/proto"
+)
+
+const testPackets = 10
+
+// gem5TestTrace returns a trace of testPackets packets with increasing ticks
+// and the offset of every packet record followed by the end of the trace
+func gem5TestTrace(t *testing.T) ([]byte, []int64) {
+	var buf bytes.Buffer
+	tickFreq, objID := uint64(1000000000000), "test"
+	w, err := NewGem5Writer(&buf, &pb.PacketHeader{TickFreq: &tickFreq, ObjId: &objID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for i := 0; i < testPackets; i++ {
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, int64(buf.Len()))
+		tick, addr, cmd, size := uint64(i+1)*1000, uint64(i)*64, uint32(1), uint32(8)
+		if err := w.WritePacket(&pb.Packet{Tick: &tick, Addr: &addr, Cmd: &cmd, Size: &size}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), append(offsets, int64(buf.Len()))
+}
+
+// readAll reads packets until an error and returns the ticks read and the
+// error, which is nil at the end of the trace
+func readAll(r *Gem5Reader) ([]uint64, error) {
+	var ticks []uint64
+	var pkt pb.Packet
+	for {
+		if err := r.ReadPacket(&pkt); err != nil {
+			if err == io.EOF {
+				return ticks, nil
+			}
+			return ticks, err
+		}
+		ticks = append(ticks, pkt.GetTick())
+	}
+}
+
+func TestGem5ReaderDamaged(t *testing.T) {
+	type result struct {
+		packets      int
+		err          error // Expected RecordError, nil if the trace ends normally
+		errRecord    int   // Record the error is reported at
+		skipped      int
+		skippedBytes func(offsets []int64) int64
+	}
+	noBytes := func([]int64) int64 { return 0 }
+	recordBytes := func(i int) func([]int64) int64 {
+		return func(offsets []int64) int64 { return offsets[i+1] - offsets[i] }
+	}
+	tests := []struct {
+		name    string
+		damage  func(data []byte, offsets []int64) []byte
+		results map[ErrorMode]result
+	}{
+		{
+			name: "intact",
+			damage: func(data []byte, offsets []int64) []byte {
+				return data
+			},
+			results: map[ErrorMode]result{
+				StopOnError:   {packets: testPackets, skippedBytes: noBytes},
+				SkipOnError:   {packets: testPackets, skippedBytes: noBytes},
+				ResyncOnError: {packets: testPackets, skippedBytes: noBytes},
+			},
+		},
+		{
+			name: "truncated final record",
+			damage: func(data []byte, offsets []int64) []byte {
+				return data[:len(data)-2]
+			},
+			results: map[ErrorMode]result{
+				StopOnError: {packets: testPackets - 1, err: ErrTruncated, errRecord: testPackets - 1, skippedBytes: noBytes},
+				SkipOnError: {packets: testPackets - 1, skipped: 1, skippedBytes: noBytes},
+				// The remainder of the record is discarded while searching for the next one
+				ResyncOnError: {packets: testPackets - 1, skipped: 1, skippedBytes: func(offsets []int64) int64 { return recordBytes(testPackets-1)(offsets) - 2 }},
+			},
+		},
+		{
+			name: "bad varint",
+			damage: func(data []byte, offsets []int64) []byte {
+				for i := offsets[4]; i < offsets[5]; i++ {
+					data[i] = 0xff
+				}
+				return data
+			},
+			results: map[ErrorMode]result{
+				StopOnError: {packets: 4, err: ErrBadVarint, errRecord: 4, skippedBytes: noBytes},
+				// The length of the record is unknown, so it can not be skipped
+				SkipOnError:   {packets: 4, err: ErrBadVarint, errRecord: 4, skippedBytes: noBytes},
+				ResyncOnError: {packets: testPackets - 1, skipped: 1, skippedBytes: recordBytes(4)},
+			},
+		},
+		{
+			name: "unmarshal error",
+			damage: func(data []byte, offsets []int64) []byte {
+				// Wire type 7 does not exist
+				data[offsets[3]+1] = 0xff
+				return data
+			},
+			results: map[ErrorMode]result{
+				StopOnError:   {packets: 3, err: ErrUnmarshal, errRecord: 3, skippedBytes: noBytes},
+				SkipOnError:   {packets: testPackets - 1, skipped: 1, skippedBytes: recordBytes(3)},
+				ResyncOnError: {packets: testPackets - 1, skipped: 1, skippedBytes: recordBytes(3)},
+			},
+		},
+		{
+			name: "unmarshal error and bad varint",
+			damage: func(data []byte, offsets []int64) []byte {
+				data[offsets[3]+1] = 0xff
+				for i := offsets[7]; i < offsets[8]; i++ {
+					data[i] = 0xff
+				}
+				return data
+			},
+			results: map[ErrorMode]result{
+				StopOnError: {packets: 3, err: ErrUnmarshal, errRecord: 3, skippedBytes: noBytes},
+				// The offset of the second error accounts for the skipped record
+				SkipOnError: {packets: testPackets - 4, err: ErrBadVarint, errRecord: 7, skipped: 1, skippedBytes: recordBytes(3)},
+				ResyncOnError: {packets: testPackets - 2, skipped: 2, skippedBytes: func(offsets []int64) int64 {
+					return recordBytes(3)(offsets) + recordBytes(7)(offsets)
+				}},
+			},
+		},
+	}
+	for _, test := range tests {
+		for _, mode := range []ErrorMode{StopOnError, SkipOnError, ResyncOnError} {
+			t.Run(test.name+"/"+mode.String(), func(t *testing.T) {
+				data, offsets := gem5TestTrace(t)
+				data = test.damage(data, offsets)
+				expected := test.results[mode]
+				r, err := NewGem5Reader(bufio.NewReader(bytes.NewReader(data)), Options{OnError: mode})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ticks, err := readAll(r)
+				if len(ticks) != expected.packets {
+					t.Errorf("Read %d packets, expected %d", len(ticks), expected.packets)
+				}
+				for i := 1; i < len(ticks); i++ {
+					if ticks[i] <= ticks[i-1] {
+						t.Errorf("Tick %d after %d", ticks[i], ticks[i-1])
+					}
+				}
+				if expected.err == nil {
+					if err != nil {
+						t.Fatalf("Unexpected error: %v", err)
+					}
+				} else {
+					var recErr *RecordError
+					if !errors.As(err, &recErr) || !errors.Is(err, expected.err) {
+						t.Fatalf("Got error %v, expected %v", err, expected.err)
+					}
+					if recErr.Offset != offsets[expected.errRecord] {
+						t.Errorf("Error at offset %d, expected %d", recErr.Offset, offsets[expected.errRecord])
+					}
+				}
+				if r.Skipped != expected.skipped {
+					t.Errorf("Skipped %d packets, expected %d", r.Skipped, expected.skipped)
+				}
+				if bytes := expected.skippedBytes(offsets); r.SkippedBytes != bytes {
+					t.Errorf("Skipped %d bytes, expected %d", r.SkippedBytes, bytes)
+				}
+				if expected.err == nil && expected.skipped == 0 && r.offset != int64(len(data)) {
+					t.Errorf("Offset %d at the end, expected %d", r.offset, len(data))
+				}
+			})
+		}
+	}
+}
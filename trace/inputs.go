@@ -0,0 +1,209 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Role describes what a trace input contains
+type Role uint8
+
+const (
+	// RoleUnset is used when no role was given for an input
+	RoleUnset Role = iota
+	// RoleData inputs contain the data accesses of a CPU
+	RoleData
+	// RoleFetch inputs contain the instruction fetches of a CPU
+	RoleFetch
+	// RoleMiss inputs contain the misses of a (shared) cache
+	RoleMiss
+)
+
+var roleNames = [...]string{
+	RoleUnset: "unset",
+	RoleData:  "data",
+	RoleFetch: "ifetch",
+	RoleMiss:  "miss",
+}
+
+func (r Role) String() string {
+	if int(r) < len(roleNames) {
+		return roleNames[r]
+	}
+	return "unset"
+}
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) (Role, error) {
+	for r, n := range roleNames {
+		if n == name && Role(r) != RoleUnset {
+			return Role(r), nil
+		}
+	}
+	return RoleUnset, fmt.Errorf("Unknown input role %q", name)
+}
+
+// InputSpec describes a trace input and how its accesses are labelled
+type InputSpec struct {
+	Path string
+	CPU  int // -1 if not given
+	Role Role
+}
+
+// Apply labels a with the CPU and role of the input
+func (s InputSpec) Apply(a *Access) {
+	if s.CPU >= 0 {
+		a.CPU = s.CPU
+	}
+	if s.Role == RoleFetch && a.Kind.IsRead() {
+		a.Kind = Fetch
+	}
+	a.Miss = s.Role == RoleMiss
+}
+
+func (s InputSpec) String() string {
+	return fmt.Sprintf("path=%s,cpu=%d,role=%s", s.Path, s.CPU, s.Role)
+}
+
+// ParseInputSpec parses an input description of the form
+// 'path=cpu0.icache.gz,cpu=0,role=ifetch' where only path is required
+func ParseInputSpec(desc string) (InputSpec, error) {
+	spec := InputSpec{CPU: -1}
+	for _, attr := range strings.Split(desc, ",") {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return spec, fmt.Errorf("Invalid input attribute %q in %q", attr, desc)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "path":
+			spec.Path = value
+		case "cpu":
+			cpu, err := strconv.Atoi(value)
+			if err != nil || cpu < 0 {
+				return spec, fmt.Errorf("Invalid cpu %q in %q", value, desc)
+			}
+			spec.CPU = cpu
+		case "role":
+			role, err := ParseRole(value)
+			if err != nil {
+				return spec, err
+			}
+			spec.Role = role
+		default:
+			return spec, fmt.Errorf("Unknown input attribute %q in %q", key, desc)
+		}
+	}
+	if spec.Path == "" {
+		return spec, fmt.Errorf("No path given in %q", desc)
+	}
+	return spec, nil
+}
+
+// InputSpecs is a list of inputs that can be used as flag, every value is
+// either a comma separated list of paths or a single input description as
+// accepted by ParseInputSpec
+type InputSpecs []InputSpec
+
+func (l *InputSpecs) String() string {
+	if l == nil {
+		return ""
+	}
+	descs := make([]string, len(*l))
+	for i, s := range *l {
+		descs[i] = s.String()
+	}
+	return strings.Join(descs, " ")
+}
+
+// Set adds the inputs described by value
+func (l *InputSpecs) Set(value string) error {
+	if strings.Contains(value, "=") {
+		spec, err := ParseInputSpec(value)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, spec)
+		return nil
+	}
+	for _, path := range strings.Split(value, ",") {
+		if path != "" {
+			*l = append(*l, InputSpec{Path: path, CPU: -1})
+		}
+	}
+	return nil
+}
+
+// ReadManifest adds the inputs listed in the manifest at path, which contains
+// one input description per line, empty lines and lines starting with # are
+// ignored. Relative paths are resolved relative to the manifest.
+func (l *InputSpecs) ReadManifest(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Unable to open manifest: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "=") {
+			line = "path=" + line
+		}
+		spec, err := ParseInputSpec(line)
+		if err != nil {
+			return fmt.Errorf("Manifest line %d: %w", lineNr, err)
+		}
+		if !filepath.IsAbs(spec.Path) {
+			spec.Path = filepath.Join(filepath.Dir(path), spec.Path)
+		}
+		*l = append(*l, spec)
+	}
+	return scanner.Err()
+}
+
+// Resolve fills in the attributes that were not given explicitly using the
+// positional layout
+func (l InputSpecs) Resolve(layout func(idx int) InputSpec) {
+	for idx := range l {
+		def := layout(idx)
+		if l[idx].CPU < 0 {
+			l[idx].CPU = def.CPU
+		}
+		if l[idx].Role == RoleUnset {
+			l[idx].Role = def.Role
+		}
+	}
+}
+
+// SplitCacheLayout is the positional layout in which the inputs alternate
+// between the data and instruction stream of every CPU
+func SplitCacheLayout(idx int) InputSpec {
+	role := RoleData
+	if idx%2 == 1 {
+		role = RoleFetch
+	}
+	return InputSpec{CPU: idx / 2, Role: role}
+}
+
+// MissStreamLayout is the positional layout in which the first input contains
+// the misses of the last level cache, followed by the instruction and data
+// stream of every CPU
+func MissStreamLayout(idx int) InputSpec {
+	if idx == 0 {
+		return InputSpec{CPU: 0, Role: RoleMiss}
+	}
+	role := RoleData
+	if idx%2 == 1 {
+		role = RoleFetch
+	}
+	return InputSpec{CPU: (idx - 1) / 2, Role: role}
+}
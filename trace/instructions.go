@@ -0,0 +1,189 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InstructionSource describes how retired instructions are counted
+type InstructionSource uint8
+
+const (
+	// NoInstructions disables instruction counting
+	NoInstructions InstructionSource = iota
+	// FetchInstructions counts every instruction fetch as one instruction,
+	// exact for QEMU traces but an estimate for gem5 fetch streams that
+	// fetch a cache line at a time
+	FetchInstructions
+	// PCInstructions counts the changes of the program counter of every
+	// CPU, accesses of the same instruction share its program counter
+	PCInstructions
+	// InputInstructions interpolates the instruction counts of a side input
+	InputInstructions
+)
+
+var instructionSourceNames = [...]string{
+	NoInstructions:    "none",
+	FetchInstructions: "fetch",
+	PCInstructions:    "pc",
+	InputInstructions: "input",
+}
+
+func (s InstructionSource) String() string {
+	if int(s) < len(instructionSourceNames) {
+		return instructionSourceNames[s]
+	}
+	return "unknown"
+}
+
+// ParseInstructionSource returns the instruction source with the given name
+func ParseInstructionSource(name string) (InstructionSource, error) {
+	for s, n := range instructionSourceNames {
+		if n == name {
+			return InstructionSource(s), nil
+		}
+	}
+	return NoInstructions, fmt.Errorf("Unknown instruction source %q, expected one of %s", name, strings.Join(instructionSourceNames[:], "/"))
+}
+
+// instructionSample is the total amount of instructions retired at a tick
+type instructionSample struct {
+	tick, count uint64
+}
+
+// InstructionCounter counts the instructions retired up to the accesses
+// added to it
+type InstructionCounter struct {
+	source  InstructionSource
+	pcs     []uint64 // Last program counter of every CPU
+	samples []instructionSample
+	next    int // First sample after the last access
+	count   uint64
+}
+
+// OpenInstructionCounter returns a counter for source, the instruction counts
+// of the input source are read from path
+func OpenInstructionCounter(source InstructionSource, path string) (*InstructionCounter, error) {
+	if source != InputInstructions {
+		return &InstructionCounter{source: source}, nil
+	}
+	if path == "" {
+		return nil, fmt.Errorf("No instruction count input given")
+	}
+	return ReadInstructionCounts(path)
+}
+
+// ReadInstructionCounts returns a counter interpolating the instruction
+// counts at path, which holds lines of the form '<tick>,<instructions>' with
+// the total amount of instructions retired at the tick. Empty lines, lines
+// starting with # and a header are ignored. Gzipped files are decompressed.
+func ReadInstructionCounts(path string) (*InstructionCounter, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open instruction counts: %w", err)
+	}
+	defer f.Close()
+	c := &InstructionCounter{source: InputInstructions}
+	lineNr := 0
+	for {
+		line, err := f.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("Unable to read instruction counts: %w", err)
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		lineNr++
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid instruction count on line %d: %q", lineNr, line)
+		}
+		tick, err1 := strconv.ParseUint(fields[0], 0, 64)
+		count, err2 := strconv.ParseUint(fields[1], 0, 64)
+		if err1 != nil || err2 != nil {
+			if len(c.samples) == 0 && lineNr == 1 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("Invalid instruction count on line %d: %q", lineNr, line)
+		}
+		c.samples = append(c.samples, instructionSample{tick: tick, count: count})
+	}
+	if len(c.samples) == 0 {
+		return nil, fmt.Errorf("No instruction counts in %s", path)
+	}
+	sort.Slice(c.samples, func(i, j int) bool { return c.samples[i].tick < c.samples[j].tick })
+	if c.samples[0].tick > 0 {
+		// No instructions are retired before the start
+		c.samples = append([]instructionSample{{}}, c.samples...)
+	}
+	return c, nil
+}
+
+// Source returns how the counter counts instructions
+func (c *InstructionCounter) Source() InstructionSource {
+	return c.source
+}
+
+// Add counts the instructions retired up to a, accesses of cache miss streams
+// should not be added
+func (c *InstructionCounter) Add(a Access) {
+	switch c.source {
+	case FetchInstructions:
+		if a.Kind == Fetch {
+			c.count++
+		}
+	case PCInstructions:
+		if a.PC == 0 {
+			return
+		}
+		for len(c.pcs) <= a.CPU {
+			c.pcs = append(c.pcs, 0)
+		}
+		if c.pcs[a.CPU] != a.PC {
+			c.pcs[a.CPU] = a.PC
+			c.count++
+		}
+	case InputInstructions:
+		if count := c.at(a.Tick); count > c.count {
+			c.count = count
+		}
+	}
+}
+
+// at interpolates the amount of instructions retired at tick, ticks are
+// expected to be mostly increasing
+func (c *InstructionCounter) at(tick uint64) uint64 {
+	for c.next < len(c.samples) && c.samples[c.next].tick <= tick {
+		c.next++
+	}
+	prev := c.samples[c.next-1]
+	if tick <= prev.tick {
+		// Ticks going backwards do not move the count back
+		return prev.count
+	}
+	if c.next == len(c.samples) || prev.count >= c.samples[c.next].count {
+		return prev.count
+	}
+	next := c.samples[c.next]
+	return prev.count + uint64(float64(next.count-prev.count)*float64(tick-prev.tick)/float64(next.tick-prev.tick))
+}
+
+// Count returns the amount of instructions retired so far
+func (c *InstructionCounter) Count() uint64 {
+	return c.count
+}
+
+// PerKilo returns events per thousand instructions
+func PerKilo(events, instructions uint64) float64 {
+	if instructions == 0 {
+		return 0
+	}
+	return float64(events) * 1000 / float64(instructions)
+}
@@ -0,0 +1,96 @@
+package trace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstructionCounterAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		ticks  []uint64
+		counts []uint64
+	}{
+		{
+			name:   "interpolation",
+			ticks:  []uint64{100, 150, 200, 250, 300, 400},
+			counts: []uint64{1000, 1500, 2000, 2000, 2000, 2000},
+		},
+		{
+			// Ticks going back before the sample passed last keep its count
+			name:   "backwards",
+			ticks:  []uint64{150, 120, 200, 180, 100},
+			counts: []uint64{1500, 1200, 2000, 2000, 2000},
+		},
+		{
+			name:   "zero sample",
+			ticks:  []uint64{0, 50, 100},
+			counts: []uint64{0, 500, 1000},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &InstructionCounter{source: InputInstructions, samples: []instructionSample{
+				{tick: 0, count: 0},
+				{tick: 100, count: 1000},
+				{tick: 200, count: 2000},
+				{tick: 300, count: 2000},
+			}}
+			for i, tick := range test.ticks {
+				if count := c.at(tick); count != test.counts[i] {
+					t.Errorf("%d instructions at tick %d, expected %d", count, tick, test.counts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadInstructionCounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instructions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "counts.csv")
+	data := "tick,instructions\n# Sampled every 100 ticks\n200,2000\n\n100,1000\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadInstructionCounts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The samples are sorted and start with the implicit zero sample
+	expected := []instructionSample{{0, 0}, {100, 1000}, {200, 2000}}
+	if len(c.samples) != len(expected) {
+		t.Fatalf("Samples %v, expected %v", c.samples, expected)
+	}
+	for i := range expected {
+		if c.samples[i] != expected[i] {
+			t.Errorf("Sample %d is %v, expected %v", i, c.samples[i], expected[i])
+		}
+	}
+
+	// The count never goes back, even when the accesses do
+	for _, tick := range []uint64{50, 150, 120, 250} {
+		c.Add(Access{Tick: tick})
+	}
+	if c.Count() != 2000 {
+		t.Errorf("Counted %d instructions, expected 2000", c.Count())
+	}
+	c = &InstructionCounter{source: InputInstructions, samples: expected}
+	c.Add(Access{Tick: 150})
+	c.Add(Access{Tick: 120})
+	if c.Count() != 1500 {
+		t.Errorf("Counted %d instructions after going back, expected 1500", c.Count())
+	}
+
+	if err := ioutil.WriteFile(path, []byte("100,1000\n100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadInstructionCounts(path); err == nil {
+		t.Error("Accepted a line without instruction count")
+	}
+}
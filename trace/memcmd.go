@@ -0,0 +1,218 @@
+package trace
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Command is a gem5 MemCmd independent of the numbering used by a specific
+// gem5 version, CommandTable translates between the two
+type Command uint8
+
+// gem5 memory commands
+const (
+	InvalidCmd Command = iota
+	ReadReq
+	ReadResp
+	ReadRespWithInvalidate
+	WriteReq
+	WriteResp
+	WritebackDirty
+	WritebackClean
+	WriteClean
+	CleanEvict
+	SoftPFReq
+	SoftPFExReq
+	HardPFReq
+	SoftPFResp
+	HardPFResp
+	WriteLineReq
+	UpgradeReq
+	SCUpgradeReq
+	UpgradeResp
+	SCUpgradeFailReq
+	UpgradeFailResp
+	ReadExReq
+	ReadExResp
+	ReadCleanReq
+	ReadSharedReq
+	LoadLockedReq
+	StoreCondReq
+	StoreCondFailReq
+	StoreCondResp
+	SwapReq
+	SwapResp
+	MessageReq
+	MessageResp
+	MemFenceReq
+	MemFenceResp
+	CleanSharedReq
+	CleanSharedResp
+	CleanInvalidReq
+	CleanInvalidResp
+	InvalidDestError
+	BadAddressError
+	FunctionalReadError
+	FunctionalWriteError
+	PrintReq
+	FlushReq
+	InvalidateReq
+	InvalidateResp
+	NumCommands
+)
+
+var commandInfo = [NumCommands]struct {
+	name string
+	kind Kind
+}{
+	InvalidCmd:             {"InvalidCmd", Unknown},
+	ReadReq:                {"ReadReq", Read},
+	ReadResp:               {"ReadResp", Response},
+	ReadRespWithInvalidate: {"ReadRespWithInvalidate", Response},
+	WriteReq:               {"WriteReq", Write},
+	WriteResp:              {"WriteResp", Response},
+	WritebackDirty:         {"WritebackDirty", Writeback},
+	WritebackClean:         {"WritebackClean", Clean},
+	WriteClean:             {"WriteClean", Clean},
+	CleanEvict:             {"CleanEvict", Evict},
+	SoftPFReq:              {"SoftPFReq", Prefetch},
+	SoftPFExReq:            {"SoftPFExReq", Prefetch},
+	HardPFReq:              {"HardPFReq", Prefetch},
+	SoftPFResp:             {"SoftPFResp", Prefetch},
+	HardPFResp:             {"HardPFResp", Prefetch},
+	WriteLineReq:           {"WriteLineReq", Write},
+	UpgradeReq:             {"UpgradeReq", Write},
+	SCUpgradeReq:           {"SCUpgradeReq", Write},
+	UpgradeResp:            {"UpgradeResp", Response},
+	SCUpgradeFailReq:       {"SCUpgradeFailReq", Unknown},
+	UpgradeFailResp:        {"UpgradeFailResp", Response},
+	ReadExReq:              {"ReadExReq", Read},
+	ReadExResp:             {"ReadExResp", Response},
+	ReadCleanReq:           {"ReadCleanReq", Read},
+	ReadSharedReq:          {"ReadSharedReq", Read},
+	LoadLockedReq:          {"LoadLockedReq", Read},
+	StoreCondReq:           {"StoreCondReq", Write},
+	StoreCondFailReq:       {"StoreCondFailReq", Unknown},
+	StoreCondResp:          {"StoreCondResp", Response},
+	SwapReq:                {"SwapReq", Write},
+	SwapResp:               {"SwapResp", Response},
+	MessageReq:             {"MessageReq", Unknown},
+	MessageResp:            {"MessageResp", Response},
+	MemFenceReq:            {"MemFenceReq", Unknown},
+	MemFenceResp:           {"MemFenceResp", Response},
+	CleanSharedReq:         {"CleanSharedReq", Clean},
+	CleanSharedResp:        {"CleanSharedResp", Response},
+	CleanInvalidReq:        {"CleanInvalidReq", Invalidate},
+	CleanInvalidResp:       {"CleanInvalidResp", Response},
+	InvalidDestError:       {"InvalidDestError", Response},
+	BadAddressError:        {"BadAddressError", Response},
+	FunctionalReadError:    {"FunctionalReadError", Response},
+	FunctionalWriteError:   {"FunctionalWriteError", Response},
+	PrintReq:               {"PrintReq", Unknown},
+	FlushReq:               {"FlushReq", Clean},
+	InvalidateReq:          {"InvalidateReq", Invalidate},
+	InvalidateResp:         {"InvalidateResp", Response},
+}
+
+func (c Command) String() string {
+	if c < NumCommands {
+		return commandInfo[c].name
+	}
+	return "InvalidCmd"
+}
+
+// Kind returns the access kind of the command
+func (c Command) Kind() Kind {
+	if c < NumCommands {
+		return commandInfo[c].kind
+	}
+	return Unknown
+}
+
+// CommandTable maps the raw MemCmd values written by a gem5 version to
+// Commands
+type CommandTable struct {
+	commands []Command
+	raw      [NumCommands]uint32
+}
+
+func newCommandTable(commands ...Command) *CommandTable {
+	t := &CommandTable{commands: commands}
+	for raw, cmd := range commands {
+		if cmd != InvalidCmd {
+			t.raw[cmd] = uint32(raw)
+		}
+	}
+	return t
+}
+
+// Decode returns the Command for the raw value cmd, unknown values decode to
+// InvalidCmd
+func (t *CommandTable) Decode(cmd uint32) Command {
+	if int(cmd) < len(t.commands) {
+		return t.commands[cmd]
+	}
+	return InvalidCmd
+}
+
+// Encode returns the raw value for cmd
+func (t *CommandTable) Encode(cmd Command) uint32 {
+	return t.raw[cmd]
+}
+
+// gem5v19 is the MemCmd enumeration of gem5 19 and 20
+var gem5v19 = newCommandTable(
+	InvalidCmd, ReadReq, ReadResp, ReadRespWithInvalidate, WriteReq,
+	WriteResp, WritebackDirty, WritebackClean, WriteClean, CleanEvict,
+	SoftPFReq, SoftPFExReq, HardPFReq, SoftPFResp, HardPFResp,
+	WriteLineReq, UpgradeReq, SCUpgradeReq, UpgradeResp, SCUpgradeFailReq,
+	UpgradeFailResp, ReadExReq, ReadExResp, ReadCleanReq, ReadSharedReq,
+	LoadLockedReq, StoreCondReq, StoreCondFailReq, StoreCondResp, SwapReq,
+	SwapResp, MessageReq, MessageResp, MemFenceReq, MemFenceResp,
+	CleanSharedReq, CleanSharedResp, CleanInvalidReq, CleanInvalidResp,
+	InvalidDestError, BadAddressError, FunctionalReadError,
+	FunctionalWriteError, PrintReq, FlushReq, InvalidateReq, InvalidateResp,
+)
+
+// gem5Legacy is the numbering of the traces this tool was originally written
+// against, it matches gem5v19 up to SCUpgradeReq but has an additional command
+// in front of UpgradeResp shifting all following commands by one
+var gem5Legacy = newCommandTable(
+	InvalidCmd, ReadReq, ReadResp, ReadRespWithInvalidate, WriteReq,
+	WriteResp, WritebackDirty, WritebackClean, WriteClean, CleanEvict,
+	SoftPFReq, SoftPFExReq, HardPFReq, SoftPFResp, HardPFResp,
+	WriteLineReq, UpgradeReq, SCUpgradeReq, InvalidCmd, UpgradeResp,
+	SCUpgradeFailReq, UpgradeFailResp, ReadExReq, ReadExResp, ReadCleanReq,
+	ReadSharedReq, LoadLockedReq, StoreCondReq, StoreCondFailReq,
+	StoreCondResp, SwapReq, SwapResp, MessageReq, MessageResp, MemFenceReq,
+	MemFenceResp, CleanSharedReq, CleanSharedResp, CleanInvalidReq,
+	CleanInvalidResp, InvalidDestError, BadAddressError, FunctionalReadError,
+	FunctionalWriteError, PrintReq, FlushReq, InvalidateReq, InvalidateResp,
+)
+
+var gem5Versions = map[string]*CommandTable{
+	"legacy": gem5Legacy,
+	"v19":    gem5v19,
+	"v20":    gem5v19,
+}
+
+// DefaultGem5Version is the gem5 version assumed when none is given
+const DefaultGem5Version = "legacy"
+
+// Gem5Commands returns the command table for the given gem5 version
+func Gem5Commands(version string) (*CommandTable, error) {
+	if t, ok := gem5Versions[version]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("Unknown gem5 version %q, known versions: %v", version, Gem5Versions())
+}
+
+// Gem5Versions returns the gem5 versions for which a command table is known
+func Gem5Versions() []string {
+	versions := make([]string, 0, len(gem5Versions))
+	for v := range gem5Versions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
@@ -0,0 +1,236 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Region is a named range [Start, End) of the physical address space
+type Region struct {
+	Name  string
+	Start uint64
+	End   uint64
+}
+
+// MemoryMap is a set of non overlapping regions sorted by address
+type MemoryMap struct {
+	Regions []Region
+}
+
+// QemuPCMemoryMap is the RAM layout of a QEMU x86 pc machine with 8GiB of
+// memory, which is assumed when no memory map is given
+var QemuPCMemoryMap = &MemoryMap{Regions: []Region{
+	{Name: "ram-below-4g", Start: 0, End: 0xc0000000},
+	{Name: "ram-above-4g", Start: 0x100000000, End: 0x240000000},
+}}
+
+// FullMemoryMap returns a map with a single region covering all addresses
+func FullMemoryMap() *MemoryMap {
+	return &MemoryMap{Regions: []Region{{Name: "memory", Start: 0, End: math.MaxUint64}}}
+}
+
+// Lookup returns the index of the region containing addr or -1 if addr is
+// not mapped
+func (m *MemoryMap) Lookup(addr uint64) int {
+	i := sort.Search(len(m.Regions), func(i int) bool {
+		return m.Regions[i].End > addr
+	})
+	if i < len(m.Regions) && m.Regions[i].Start <= addr {
+		return i
+	}
+	return -1
+}
+
+// ReadMemoryMap reads the memory map stored at path, see ParseMemoryMap
+func ReadMemoryMap(path string) (*MemoryMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open memory map: %w", err)
+	}
+	defer f.Close()
+	return ParseMemoryMap(f)
+}
+
+// mtreeLine matches a region in the output of the QEMU 'info mtree' monitor
+// command, e.g.
+// 0000000000000000-00000000bfffffff (prio 0, ram): alias ram-below-4g @pc.ram 0000000000000000-00000000bfffffff
+var mtreeLine = regexp.MustCompile(`^(\s*)([0-9a-fA-F]+)-([0-9a-fA-F]+) \(prio (-?\d+), ([\w/-]+)\): (alias )?(.+)$`)
+
+// mtreeNode is a region of an mtree dump with the regions nested in it
+type mtreeNode struct {
+	Region
+	prio     int
+	depth    int
+	children []*mtreeNode
+}
+
+// flatten returns the regions visible in n. Regions with subregions are
+// containers, only their subregions are visible. Of overlapping siblings the
+// one with the highest priority wins and of equal priorities the one listed
+// first, as QEMU lists the subregions in the order it renders them.
+func (n *mtreeNode) flatten() []Region {
+	if len(n.children) == 0 {
+		return []Region{n.Region}
+	}
+	order := make([]int, len(n.children))
+	for i := range order {
+		order[i] = i
+	}
+	// Insert from lowest to highest precedence, later inserts overwrite
+	sort.Slice(order, func(i, j int) bool {
+		a, b := n.children[order[i]], n.children[order[j]]
+		if a.prio != b.prio {
+			return a.prio < b.prio
+		}
+		return order[i] > order[j]
+	})
+	m := &MemoryMap{}
+	for _, i := range order {
+		for _, r := range n.children[i].flatten() {
+			// Subregions are clipped to their container
+			if r.Start < n.Start {
+				r.Start = n.Start
+			}
+			if r.End > n.End {
+				r.End = n.End
+			}
+			if r.Start < r.End {
+				m.insert(r)
+			}
+		}
+	}
+	return m.Regions
+}
+
+// ParseMemoryMap parses either a dump of the QEMU 'info mtree' monitor command
+// or a list of regions with one '<name> <start> <end>' entry per line, where
+// end is exclusive and lines starting with # are ignored. Of an mtree dump the
+// tree of the memory address space is used, or the first tree if there is
+// none. Consecutive address-space headers share the tree printed below them.
+// The tree is flattened as QEMU does: containers only map their subregions,
+// priorities are compared among siblings and disabled regions are ignored.
+// Regions of a list that overlap earlier ones replace them.
+func ParseMemoryMap(in io.Reader) (*MemoryMap, error) {
+	root := &mtreeNode{Region: Region{Start: 0, End: math.MaxUint64}, depth: -1}
+	var list []Region
+	stack := []*mtreeNode{root} // Path to the last region of the tree
+	scanner := bufio.NewScanner(in)
+	lineNr := 0
+	trees := 0
+	inTree := false  // Whether a region followed the last header
+	selected := 1    // Tree that is used
+	memoryTree := -1 // Tree of the memory address space
+	for scanner.Scan() {
+		lineNr++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "address-space") || strings.HasPrefix(trimmed, "memory-region") {
+			if inTree || trees == 0 {
+				trees++
+			}
+			inTree = false
+			if trimmed == "address-space: memory" && memoryTree < 0 {
+				memoryTree = trees
+				selected = trees
+				// Drop the regions of earlier trees
+				root.children = nil
+				stack = stack[:1]
+			}
+			continue
+		}
+		if m := mtreeLine.FindStringSubmatch(line); m != nil {
+			inTree = true
+			if trees > selected {
+				continue
+			}
+			start, _ := strconv.ParseUint(m[2], 16, 64)
+			last, _ := strconv.ParseUint(m[3], 16, 64)
+			prio, _ := strconv.Atoi(m[4])
+			end := last + 1
+			if last == math.MaxUint64 {
+				end = last
+			}
+			name := strings.TrimSpace(strings.TrimSuffix(m[7], "[disabled]"))
+			if m[6] != "" {
+				// Aliases are followed by their target
+				if i := strings.Index(name, " @"); i >= 0 {
+					name = name[:i]
+				}
+			}
+			n := &mtreeNode{Region: Region{name, start, end}, prio: prio, depth: len(m[1])}
+			for stack[len(stack)-1].depth >= n.depth {
+				stack = stack[:len(stack)-1]
+			}
+			// Disabled regions stay on the stack so their subregions are
+			// dropped with them
+			if !strings.HasSuffix(trimmed, "[disabled]") {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Unable to parse memory map line %d: %q", lineNr, line)
+		}
+		start, err := strconv.ParseUint(fields[1], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid start address on memory map line %d: %w", lineNr, err)
+		}
+		end, err := strconv.ParseUint(fields[2], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid end address on memory map line %d: %w", lineNr, err)
+		}
+		if end <= start {
+			return nil, fmt.Errorf("Empty region on memory map line %d", lineNr)
+		}
+		list = append(list, Region{fields[0], start, end})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read memory map: %w", err)
+	}
+
+	m := &MemoryMap{}
+	if len(root.children) > 0 {
+		m.Regions = root.flatten()
+	}
+	for _, r := range list {
+		m.insert(r)
+	}
+	if len(m.Regions) == 0 {
+		return nil, fmt.Errorf("Memory map does not contain any regions")
+	}
+	return m, nil
+}
+
+func (m *MemoryMap) insert(r Region) {
+	regions := make([]Region, 0, len(m.Regions)+2)
+	for _, o := range m.Regions {
+		if o.End <= r.Start || o.Start >= r.End {
+			regions = append(regions, o)
+			continue
+		}
+		if o.Start < r.Start {
+			regions = append(regions, Region{o.Name, o.Start, r.Start})
+		}
+		if o.End > r.End {
+			regions = append(regions, Region{o.Name, r.End, o.End})
+		}
+	}
+	regions = append(regions, r)
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Start < regions[j].Start
+	})
+	m.Regions = regions
+}
@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestParseMtree flattens the 'info mtree' dump of a QEMU pc machine with 8GiB
+// of memory
+func TestParseMtree(t *testing.T) {
+	f, err := os.Open("testdata/mtree-pc.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := ParseMemoryMap(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr uint64
+		name string // Empty if the address is not mapped
+	}{
+		{0x0, "ram-below-4g"},
+		{0x9ffff, "ram-below-4g"},
+		// smram-region is the sibling with the highest priority, vga-lowmem
+		// lies in pci, which has a lower priority than the RAM
+		{0xa0000, "smram-region"},
+		// The disabled pam-ram and pam-pci aliases are ignored
+		{0xc0000, "pam-rom"},
+		{0xc4000, "pam-rom"},
+		// pc.rom and isa-bios are hidden by the RAM
+		{0xc8000, "ram-below-4g"},
+		{0xe0000, "ram-below-4g"},
+		{0xf0000, "pam-pci"},
+		{0x100000, "ram-below-4g"},
+		{0xbfffffff, "ram-below-4g"},
+		// The containers system and pci do not map their gaps
+		{0xc0000000, ""},
+		{0xfd000000, "vga.vram"},
+		{0xfebf0000, ""},
+		{0xfebf0400, "vga ioports remapped"},
+		{0xfec00000, "ioapic"},
+		{0xfed40000, "tpm-ppi"},
+		{0xfee00000, "apic-msi"},
+		{0xfffc0000, "pc.bios"},
+		{0x100000000, "ram-above-4g"},
+		{0x23fffffff, "ram-above-4g"},
+		{0x240000000, ""},
+	}
+	for _, test := range tests {
+		name := ""
+		if i := m.Lookup(test.addr); i >= 0 {
+			name = m.Regions[i].Name
+		}
+		if name != test.name {
+			t.Errorf("0x%x: region %q, expected %q", test.addr, name, test.name)
+		}
+	}
+	for _, r := range m.Regions {
+		switch r.Name {
+		case "system", "pci", "vga.mmio", "vga-lowmem", "pam-ram", "io", "dma-chan", "pc.ram":
+			t.Errorf("Region %s is mapped", r.Name)
+		}
+	}
+}
+
+// TestParseMtreeFirstTree uses the first tree if there is no memory address
+// space
+func TestParseMtreeFirstTree(t *testing.T) {
+	dump := `address-space: cpu-memory-0
+  0000000000000000-ffffffffffffffff (prio 0, i/o): system
+    0000000000000000-0000000007ffffff (prio 0, ram): ram
+
+address-space: I/O
+  0000000000000000-000000000000ffff (prio 0, i/o): io
+`
+	m, err := ParseMemoryMap(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Regions) != 1 || m.Regions[0] != (Region{"ram", 0, 0x8000000}) {
+		t.Errorf("Regions %v, expected only ram", m.Regions)
+	}
+}
+
+func TestParseRegionList(t *testing.T) {
+	list := `# name start end
+low 0x0 0x1000
+high 0x2000 0x3000
+hole 0x2800 0x2900
+`
+	m, err := ParseMemoryMap(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Region{{"low", 0, 0x1000}, {"high", 0x2000, 0x2800}, {"hole", 0x2800, 0x2900}, {"high", 0x2900, 0x3000}}
+	if len(m.Regions) != len(expected) {
+		t.Fatalf("Regions %v, expected %v", m.Regions, expected)
+	}
+	for i := range expected {
+		if m.Regions[i] != expected[i] {
+			t.Errorf("Region %d: %v, expected %v", i, m.Regions[i], expected[i])
+		}
+	}
+	if _, err := ParseMemoryMap(strings.NewReader("low 0x1000 0x1000\n")); err == nil {
+		t.Error("Empty region accepted")
+	}
+}
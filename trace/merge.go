@@ -0,0 +1,94 @@
+package trace
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+)
+
+// Merger merges several traces into a single stream ordered by time using a
+// min-heap, so inputs with different tick frequencies are merged correctly.
+// Accesses with equal times are ordered by tick and then by input.
+type Merger struct {
+	inputs []Reader
+	heap   accessHeap
+	primed bool
+}
+
+// NewMerger returns a Merger reading from inputs, the Input field of every
+// returned access is set to the index of the input it was read from
+func NewMerger(inputs ...Reader) *Merger {
+	return &Merger{
+		inputs: inputs,
+		heap:   make(accessHeap, 0, len(inputs)),
+	}
+}
+
+// Next returns the access with the smallest time among all inputs. Inputs
+// that are exhausted are dropped from the merge, io.EOF is returned once all
+// inputs are exhausted.
+func (m *Merger) Next() (Access, error) {
+	if !m.primed {
+		for idx := range m.inputs {
+			if err := m.fill(idx); err != nil {
+				return Access{}, err
+			}
+		}
+		heap.Init(&m.heap)
+		m.primed = true
+	}
+	if len(m.heap) == 0 {
+		return Access{}, io.EOF
+	}
+	a := m.heap[0]
+	next, err := m.inputs[a.Input].Next()
+	if err == nil {
+		next.Input = a.Input
+		m.heap[0] = next
+		heap.Fix(&m.heap, 0)
+	} else if errors.Is(err, io.EOF) {
+		heap.Pop(&m.heap)
+	} else {
+		return Access{}, err
+	}
+	return a, nil
+}
+
+// fill reads the first access of input idx into the heap
+func (m *Merger) fill(idx int) error {
+	a, err := m.inputs[idx].Next()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	a.Input = idx
+	m.heap = append(m.heap, a)
+	return nil
+}
+
+type accessHeap []Access
+
+func (h accessHeap) Len() int { return len(h) }
+
+func (h accessHeap) Less(i, j int) bool {
+	if h[i].Time != h[j].Time {
+		return h[i].Time < h[j].Time
+	}
+	if h[i].Tick != h[j].Tick {
+		return h[i].Tick < h[j].Tick
+	}
+	return h[i].Input < h[j].Input
+}
+
+func (h accessHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *accessHeap) Push(x interface{}) { *h = append(*h, x.(Access)) }
+
+func (h *accessHeap) Pop() interface{} {
+	old := *h
+	a := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return a
+}
@@ -0,0 +1,107 @@
+package trace
+
+import (
+	"io"
+	"testing"
+)
+
+// sliceReader returns the accesses of a slice
+type sliceReader struct {
+	accesses []Access
+}
+
+func (r *sliceReader) Next() (Access, error) {
+	if len(r.accesses) == 0 {
+		return Access{}, io.EOF
+	}
+	a := r.accesses[0]
+	r.accesses = r.accesses[1:]
+	return a, nil
+}
+
+// timedAccesses returns accesses at the given times, the address identifies
+// the access in the merged stream
+func timedAccesses(base uint64, times ...uint64) []Access {
+	accesses := make([]Access, len(times))
+	for i, time := range times {
+		accesses[i] = Access{Time: time, Tick: time, Addr: base + uint64(i)}
+	}
+	return accesses
+}
+
+// mergeAll returns all accesses of m
+func mergeAll(t *testing.T, m *Merger) []Access {
+	var merged []Access
+	for {
+		a, err := m.Next()
+		if err == io.EOF {
+			return merged
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		merged = append(merged, a)
+	}
+}
+
+func TestMerger(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams [][]Access
+		// Addresses and inputs of the merged accesses
+		addrs  []uint64
+		inputs []int
+	}{
+		{
+			// The other inputs keep draining after the first one ends
+			name: "exhausted input",
+			streams: [][]Access{
+				timedAccesses(0x100, 1, 4),
+				timedAccesses(0x200, 2, 5, 6, 7),
+				timedAccesses(0x300, 3, 8),
+				nil,
+			},
+			addrs:  []uint64{0x100, 0x200, 0x300, 0x101, 0x201, 0x202, 0x203, 0x301},
+			inputs: []int{0, 1, 2, 0, 1, 1, 1, 2},
+		},
+		{
+			// Equal times and ticks are ordered by input, whatever order
+			// they are read in
+			name: "ties",
+			streams: [][]Access{
+				timedAccesses(0x100, 5, 5),
+				timedAccesses(0x200, 1, 5),
+				timedAccesses(0x300, 5),
+			},
+			addrs:  []uint64{0x200, 0x100, 0x101, 0x201, 0x300},
+			inputs: []int{1, 0, 0, 1, 2},
+		},
+		{
+			// Equal times are ordered by tick before input
+			name: "tick",
+			streams: [][]Access{
+				{{Time: 1, Tick: 1000, Addr: 0x100}},
+				{{Time: 1, Tick: 1, Addr: 0x200}},
+			},
+			addrs:  []uint64{0x200, 0x100},
+			inputs: []int{1, 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var readers []Reader
+			for _, accesses := range test.streams {
+				readers = append(readers, &sliceReader{accesses: accesses})
+			}
+			merged := mergeAll(t, NewMerger(readers...))
+			if len(merged) != len(test.addrs) {
+				t.Fatalf("Merged %d accesses, expected %d", len(merged), len(test.addrs))
+			}
+			for i, a := range merged {
+				if a.Addr != test.addrs[i] || a.Input != test.inputs[i] {
+					t.Errorf("Access %d is %#x of input %d, expected %#x of input %d", i, a.Addr, a.Input, test.addrs[i], test.inputs[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const qemuMagic = "QEMUTRC\x00"
+
+// QemuVersion is the version of the QEMU trace header written by QemuWriter
+const QemuVersion = 1
+
+const qemuHeaderSize = 24
+
+// QemuLayout identifies the layout of the records in a QEMU trace
+type QemuLayout uint8
+
+const (
+	// LayoutQemu records consist of addr (u64), tick (u64), type (u8) and
+	// size in bytes (u8), where type is 2 for writes, 3 for fetches and reads
+	// otherwise. This is the layout written by the instrumented QEMU.
+	LayoutQemu QemuLayout = iota
+	// LayoutConverter records consist of tick (u64), addr (u64), type (u8)
+	// and cpu (u8), where type is 0 for reads, 1 for writes and 2 for fetches
+	LayoutConverter
+	// LayoutFull records consist of tick (u64), addr (u64), type (u8), size
+	// in bytes (u8) and cpu (u8), where type is encoded as in LayoutConverter
+	LayoutFull
+)
+
+var qemuLayoutNames = [...]string{
+	LayoutQemu:      "qemu",
+	LayoutConverter: "converter",
+	LayoutFull:      "full",
+}
+
+// ParseQemuLayout returns the layout with the given name
+func ParseQemuLayout(name string) (QemuLayout, error) {
+	for l, n := range qemuLayoutNames {
+		if n == name {
+			return QemuLayout(l), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown QEMU record layout %q", name)
+}
+
+func (l QemuLayout) String() string {
+	if int(l) < len(qemuLayoutNames) {
+		return qemuLayoutNames[l]
+	}
+	return fmt.Sprintf("layout(%d)", uint8(l))
+}
+
+func (l QemuLayout) recordSize() int {
+	if l == LayoutFull {
+		return 19
+	}
+	return 18
+}
+
+// QemuHeader describes the contents of a QEMU trace, it is stored as
+//
+//	magic "QEMUTRC\0" (8 bytes)
+//	byte order ('L' or 'B', u8)
+//	version (u8)
+//	layout (u8)
+//	reserved (u8)
+//	cpus (u32)
+//	tick frequency in ticks per second (u64)
+//
+// where all integers, including those in the records, use the given byte
+// order
+type QemuHeader struct {
+	Version   uint8
+	Layout    QemuLayout
+	ByteOrder binary.ByteOrder
+	CPUs      uint32
+	TickFreq  uint64 // Zero if unknown
+}
+
+// LegacyQemuHeader describes the traces written before the header was
+// introduced
+var LegacyQemuHeader = QemuHeader{
+	Version:   0,
+	Layout:    LayoutQemu,
+	ByteOrder: binary.LittleEndian,
+	CPUs:      1,
+}
+
+// QemuReader reads the binary traces written by the instrumented QEMU and by
+// QemuWriter
+type QemuReader struct {
+	in     *bufio.Reader
+	closer io.Closer
+	record []byte
+	offset int64
+	// A truncated record at the end of the trace is treated as end of file
+	// unless stopping on errors
+	onError ErrorMode
+	ticks   tickRange
+	// Header is the header of the trace, or the fallback header if the
+	// trace has none
+	Header QemuHeader
+}
+
+// OpenQemu opens the QEMU trace located at path, see NewQemuReader
+func OpenQemu(path string, fallback QemuHeader, opts Options) (*QemuReader, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open input: %w", err)
+	}
+	r, err := NewQemuReader(f.Reader, fallback, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// NewQemuReader returns a reader decoding records from in. If in starts with
+// a header the records are decoded accordingly, otherwise fallback is used.
+func NewQemuReader(in *bufio.Reader, fallback QemuHeader, opts Options) (*QemuReader, error) {
+	r := &QemuReader{in: in, Header: fallback, onError: opts.OnError, ticks: newTickRange(opts)}
+	magic, err := in.Peek(len(qemuMagic))
+	if err == nil && string(magic) == qemuMagic {
+		if r.Header, err = readQemuHeader(in); err != nil {
+			return nil, err
+		}
+		r.offset = qemuHeaderSize
+	} else if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Unable to read header: %w", err)
+	}
+	r.record = make([]byte, r.Header.Layout.recordSize())
+	return r, nil
+}
+
+func readQemuHeader(in io.Reader) (QemuHeader, error) {
+	b := make([]byte, qemuHeaderSize)
+	if _, err := io.ReadFull(in, b); err != nil {
+		return QemuHeader{}, fmt.Errorf("Unable to read header: %w", err)
+	}
+	h := QemuHeader{
+		Version: b[9],
+		Layout:  QemuLayout(b[10]),
+	}
+	switch b[8] {
+	case 'L':
+		h.ByteOrder = binary.LittleEndian
+	case 'B':
+		h.ByteOrder = binary.BigEndian
+	default:
+		return QemuHeader{}, fmt.Errorf("Unknown byte order %q in header", b[8])
+	}
+	if h.Version > QemuVersion {
+		return QemuHeader{}, fmt.Errorf("Unsupported trace version %d", h.Version)
+	}
+	if int(h.Layout) >= len(qemuLayoutNames) {
+		return QemuHeader{}, fmt.Errorf("Unsupported record layout %d", h.Layout)
+	}
+	h.CPUs = h.ByteOrder.Uint32(b[12:16])
+	h.TickFreq = h.ByteOrder.Uint64(b[16:24])
+	return h, nil
+}
+
+// Next returns the next selected record as normalized access
+func (r *QemuReader) Next() (Access, error) {
+	for {
+		a, err := r.read()
+		if err != nil {
+			return Access{}, err
+		}
+		ok, err := r.ticks.accept(&a)
+		if err != nil {
+			return Access{}, err
+		}
+		if ok {
+			return a, nil
+		}
+	}
+}
+
+// read decodes the next record
+func (r *QemuReader) read() (Access, error) {
+	n, err := io.ReadFull(r.in, r.record)
+	if err != nil {
+		if err == io.EOF || (err == io.ErrUnexpectedEOF && r.onError != StopOnError) {
+			return Access{}, io.EOF
+		}
+		return Access{}, &RecordError{Offset: r.offset, Err: ErrTruncated, Cause: err}
+	}
+	r.offset += int64(n)
+	order := r.Header.ByteOrder
+	var a Access
+	switch r.Header.Layout {
+	case LayoutQemu:
+		a.Addr = order.Uint64(r.record[0:8])
+		a.Tick = order.Uint64(r.record[8:16])
+		switch r.record[16] {
+		case 2:
+			a.Kind = Write
+		case 3:
+			a.Kind = Fetch
+		default:
+			a.Kind = Read
+		}
+		a.Size = uint32(r.record[17])
+	case LayoutConverter, LayoutFull:
+		a.Tick = order.Uint64(r.record[0:8])
+		a.Addr = order.Uint64(r.record[8:16])
+		switch r.record[16] {
+		case 1:
+			a.Kind = Write
+		case 2:
+			a.Kind = Fetch
+		default:
+			a.Kind = Read
+		}
+		if r.Header.Layout == LayoutFull {
+			a.Size = uint32(r.record[17])
+			a.CPU = int(r.record[18])
+		} else {
+			a.CPU = int(r.record[17])
+		}
+	}
+	a.Time = ticksToNanos(a.Tick, r.Header.TickFreq)
+	if a.Kind == Write {
+		a.Cmd = WriteReq
+	} else {
+		a.Cmd = ReadReq
+	}
+	return a, nil
+}
+
+// Close closes the underlying file if the reader was created by OpenQemu
+func (r *QemuReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// QemuWriter writes accesses as QEMU trace
+type QemuWriter struct {
+	out    io.Writer
+	header QemuHeader
+	record []byte
+}
+
+// NewQemuWriter writes header to out and returns a writer encoding records
+// accordingly, the version of the header is always set to QemuVersion
+func NewQemuWriter(out io.Writer, header QemuHeader) (*QemuWriter, error) {
+	header.Version = QemuVersion
+	if header.ByteOrder == nil {
+		header.ByteOrder = binary.LittleEndian
+	}
+	b := make([]byte, qemuHeaderSize)
+	copy(b, qemuMagic)
+	if header.ByteOrder == binary.ByteOrder(binary.BigEndian) {
+		b[8] = 'B'
+	} else {
+		b[8] = 'L'
+	}
+	b[9] = header.Version
+	b[10] = uint8(header.Layout)
+	header.ByteOrder.PutUint32(b[12:16], header.CPUs)
+	header.ByteOrder.PutUint64(b[16:24], header.TickFreq)
+	if _, err := out.Write(b); err != nil {
+		return nil, fmt.Errorf("Unable to write header: %w", err)
+	}
+	return &QemuWriter{
+		out:    out,
+		header: header,
+		record: make([]byte, header.Layout.recordSize()),
+	}, nil
+}
+
+// Write appends a to the trace
+func (w *QemuWriter) Write(a Access) error {
+	order := w.header.ByteOrder
+	switch w.header.Layout {
+	case LayoutQemu:
+		order.PutUint64(w.record[0:8], a.Addr)
+		order.PutUint64(w.record[8:16], a.Tick)
+		switch {
+		case a.Kind == Fetch:
+			w.record[16] = 3
+		case a.Kind.IsWrite():
+			w.record[16] = 2
+		default:
+			w.record[16] = 0
+		}
+		w.record[17] = sizeByte(a.Size)
+	case LayoutConverter, LayoutFull:
+		order.PutUint64(w.record[0:8], a.Tick)
+		order.PutUint64(w.record[8:16], a.Addr)
+		switch {
+		case a.Kind == Fetch:
+			w.record[16] = 2
+		case a.Kind.IsWrite():
+			w.record[16] = 1
+		default:
+			w.record[16] = 0
+		}
+		if w.header.Layout == LayoutFull {
+			w.record[17] = sizeByte(a.Size)
+			w.record[18] = uint8(a.CPU)
+		} else {
+			w.record[17] = uint8(a.CPU)
+		}
+	}
+	_, err := w.out.Write(w.record)
+	return err
+}
+
+// sizeByte returns size as stored in a record, sizes that do not fit are
+// saturated
+func sizeByte(size uint32) uint8 {
+	if size > 0xff {
+		return 0xff
+	}
+	return uint8(size)
+}
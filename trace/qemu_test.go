@@ -0,0 +1,142 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+var qemuTestAccesses = []Access{
+	{Tick: 1000, Addr: 0x1000, Kind: Read, Size: 8, CPU: 0},
+	{Tick: 2000, Addr: 0xffffffff00000040, Kind: Write, Size: 4, CPU: 1},
+	{Tick: 4000, Addr: 0x7c00, Kind: Fetch, Size: 2, CPU: 3},
+	{Tick: 1 << 40, Addr: 0x2000, Kind: Read, Size: 64, CPU: 2},
+}
+
+// expectedQemuAccess returns a as read from a trace of the given layout and
+// tick frequency
+func expectedQemuAccess(a Access, layout QemuLayout, tickFreq uint64) Access {
+	if layout == LayoutConverter {
+		a.Size = 0
+	}
+	if layout == LayoutQemu {
+		a.CPU = 0
+	}
+	a.Time = ticksToNanos(a.Tick, tickFreq)
+	a.Cmd = ReadReq
+	if a.Kind == Write {
+		a.Cmd = WriteReq
+	}
+	return a
+}
+
+// readQemuAccesses reads all accesses of the trace data and returns them with
+// the header used to decode them
+func readQemuAccesses(t *testing.T, data []byte, fallback QemuHeader) (QemuHeader, []Access) {
+	r, err := NewQemuReader(bufio.NewReader(bytes.NewReader(data)), fallback, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accesses []Access
+	for {
+		a, err := r.Next()
+		if err == io.EOF {
+			return r.Header, accesses
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		accesses = append(accesses, a)
+	}
+}
+
+func TestQemuRoundTrip(t *testing.T) {
+	const tickFreq = 2000000000
+	for _, layout := range []QemuLayout{LayoutQemu, LayoutConverter, LayoutFull} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			t.Run(layout.String()+"/"+order.String(), func(t *testing.T) {
+				var buf bytes.Buffer
+				header := QemuHeader{Layout: layout, ByteOrder: order, CPUs: 4, TickFreq: tickFreq}
+				w, err := NewQemuWriter(&buf, header)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, a := range qemuTestAccesses {
+					if err := w.Write(a); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if buf.Len() != qemuHeaderSize+len(qemuTestAccesses)*layout.recordSize() {
+					t.Fatalf("Trace of %d bytes", buf.Len())
+				}
+
+				// The header overrides the fallback
+				read, accesses := readQemuAccesses(t, buf.Bytes(), LegacyQemuHeader)
+				header.Version = QemuVersion
+				if read != header {
+					t.Errorf("Read header %+v, expected %+v", read, header)
+				}
+				checkQemuAccesses(t, accesses, layout, tickFreq)
+
+				// Without header the fallback is used
+				fallback := QemuHeader{Layout: layout, ByteOrder: order, CPUs: 4}
+				read, accesses = readQemuAccesses(t, buf.Bytes()[qemuHeaderSize:], fallback)
+				if read != fallback {
+					t.Errorf("Read header %+v, expected the fallback %+v", read, fallback)
+				}
+				checkQemuAccesses(t, accesses, layout, 0)
+			})
+		}
+	}
+}
+
+// checkQemuAccesses compares accesses with qemuTestAccesses as read from a
+// trace of the given layout and tick frequency
+func checkQemuAccesses(t *testing.T, accesses []Access, layout QemuLayout, tickFreq uint64) {
+	t.Helper()
+	if len(accesses) != len(qemuTestAccesses) {
+		t.Fatalf("Read %d accesses, expected %d", len(accesses), len(qemuTestAccesses))
+	}
+	for i, a := range accesses {
+		if expected := expectedQemuAccess(qemuTestAccesses[i], layout, tickFreq); a != expected {
+			t.Errorf("Access %d is %+v, expected %+v", i, a, expected)
+		}
+	}
+}
+
+func TestQemuTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewQemuWriter(&buf, QemuHeader{Layout: LayoutFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range qemuTestAccesses {
+		if err := w.Write(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := buf.Bytes()[:buf.Len()-3]
+	for _, mode := range []ErrorMode{StopOnError, SkipOnError} {
+		r, err := NewQemuReader(bufio.NewReader(bytes.NewReader(data)), LegacyQemuHeader, Options{OnError: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for ; ; n++ {
+			if _, err = r.Next(); err != nil {
+				break
+			}
+		}
+		if n != len(qemuTestAccesses)-1 {
+			t.Errorf("%s: read %d accesses, expected %d", mode, n, len(qemuTestAccesses)-1)
+		}
+		if mode == StopOnError && !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: got error %v, expected %v", mode, err, ErrTruncated)
+		} else if mode != StopOnError && err != io.EOF {
+			t.Errorf("%s: got error %v, expected the end of the trace", mode, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package trace reads memory traces recorded by gem5 and QEMU and normalizes
+// them into a common stream of Access records.
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"strings"
+)
+
+// Kind describes what kind of memory access a record represents
+type Kind uint8
+
+const (
+	// Unknown is used for records whose command could not be classified
+	Unknown Kind = iota
+	// Read is a data read
+	Read
+	// Write is a data write
+	Write
+	// Fetch is an instruction fetch
+	Fetch
+	// Writeback writes back a dirty cache line
+	Writeback
+	// Clean writes back a clean cache line or asks the caches to clean or
+	// flush a line, it carries no dirty data to memory
+	Clean
+	// Evict notifies the eviction of a clean cache line
+	Evict
+	// Prefetch is a hardware or software prefetch
+	Prefetch
+	// Invalidate invalidates a cache line
+	Invalidate
+	// Response is a response to an earlier request
+	Response
+)
+
+var kindNames = [...]string{
+	Unknown:    "unknown",
+	Read:       "read",
+	Write:      "write",
+	Fetch:      "fetch",
+	Writeback:  "writeback",
+	Clean:      "clean",
+	Evict:      "evict",
+	Prefetch:   "prefetch",
+	Invalidate: "invalidate",
+	Response:   "response",
+}
+
+// IsRead reports whether the access reads data from memory
+func (k Kind) IsRead() bool {
+	return k == Read || k == Prefetch
+}
+
+// IsWrite reports whether the access writes data to memory
+func (k Kind) IsWrite() bool {
+	return k == Write || k == Writeback
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "unknown"
+}
+
+// Access is a single normalized memory access
+type Access struct {
+	Tick  uint64
+	Time  uint64 // Tick in nanoseconds, equal to Tick if the frequency is unknown
+	Addr  uint64
+	Size  uint32
+	Kind  Kind
+	CPU   int
+	PC    uint64       // Program counter of the instruction, zero if unknown
+	Flags RequestFlags // gem5 request flags, zero for QEMU traces
+	PktID uint64       // gem5 packet id, zero if unknown
+	Miss  bool         // Set for accesses read from a cache miss stream
+	Cmd   Command      // gem5 command, ReadReq or WriteReq for QEMU traces
+	Input int          // Index of the input the access was read from when merging
+}
+
+// ticksToNanos converts tick to nanoseconds at a frequency of freq ticks
+// per second
+func ticksToNanos(tick, freq uint64) uint64 {
+	if freq == 0 || freq == 1e9 {
+		return tick
+	}
+	hi, lo := bits.Mul64(tick, 1e9)
+	if hi >= freq {
+		return math.MaxUint64
+	}
+	ns, _ := bits.Div64(hi, lo, freq)
+	return ns
+}
+
+// Reader is implemented by all trace readers, Next returns io.EOF when the
+// trace is exhausted
+type Reader interface {
+	Next() (Access, error)
+}
+
+// Options configures how traces are read
+type Options struct {
+	// Commands is used to decode gem5 packet commands, the table of
+	// DefaultGem5Version is used if nil
+	Commands *CommandTable
+	// OnError determines how damaged records are handled
+	OnError ErrorMode
+	// MaxMessageSize is the largest gem5 message in bytes that is accepted,
+	// DefaultMaxMessageSize is used if zero
+	MaxMessageSize int
+	// Skip is the amount of accesses discarded at the start and Limit, if
+	// not zero, the maximum amount of accesses returned after that. Readers
+	// ignore both, Select applies them to the merged stream so all inputs
+	// cover the same range.
+	Skip, Limit uint64
+	// StartTick and EndTick restrict every input to the accesses with
+	// StartTick <= tick < EndTick, an EndTick of zero means no end. Ticks of
+	// inputs with different tick frequencies are not comparable.
+	StartTick, EndTick uint64
+}
+
+// tickRange applies the tick range of Options to the accesses of one input
+type tickRange struct {
+	start, end uint64
+}
+
+func newTickRange(opts Options) tickRange {
+	return tickRange{start: opts.StartTick, end: opts.EndTick}
+}
+
+// accept reports whether a is selected, it returns io.EOF when a lies past
+// the end of the tick range
+func (t tickRange) accept(a *Access) (bool, error) {
+	if t.end != 0 && a.Tick >= t.end {
+		return false, io.EOF
+	}
+	return a.Tick >= t.start, nil
+}
+
+// selection discards the first accesses of a stream and limits the amount
+// returned after them
+type selection struct {
+	in          Reader
+	skip, limit uint64
+	count       uint64 // Accesses read so far
+}
+
+// Select returns a reader applying the skip and limit of opts to in
+func Select(in Reader, opts Options) Reader {
+	if opts.Skip == 0 && opts.Limit == 0 {
+		return in
+	}
+	return &selection{in: in, skip: opts.Skip, limit: opts.Limit}
+}
+
+// Next returns the next selected access
+func (s *selection) Next() (Access, error) {
+	for {
+		if s.limit != 0 && s.count >= s.skip+s.limit {
+			return Access{}, io.EOF
+		}
+		a, err := s.in.Next()
+		if err != nil {
+			return Access{}, err
+		}
+		s.count++
+		if s.count > s.skip {
+			return a, nil
+		}
+	}
+}
+
+// DefaultMaxMessageSize is the default limit on the size of gem5 messages
+const DefaultMaxMessageSize = 1 << 20
+
+type file struct {
+	*bufio.Reader
+	closers []io.Closer
+}
+
+func (f *file) Close() error {
+	var err error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		if cerr := f.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openFile opens the file at path for buffered reading, transparently
+// decompressing it when the name ends in .gz
+func openFile(path string) (*file, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return &file{Reader: bufio.NewReader(f), closers: []io.Closer{f}}, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &file{Reader: bufio.NewReader(gz), closers: []io.Closer{f, gz}}, nil
+}